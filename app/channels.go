@@ -0,0 +1,51 @@
+// Copyright (c) 2015-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package app
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/mattermost/mattermost-server/v6/model"
+)
+
+// configSvc is the subset of configWrapper's behavior Channels needs to
+// read the active configuration and, where supported, reach its secret
+// registry.
+type configSvc interface {
+	Config() *model.Config
+}
+
+// Channels holds the per-server state this package's config, secret, and
+// signing-key code depends on. Only the fields those files actually touch
+// are declared here; the rest of Channels' real responsibilities
+// (products, jobs, plugins, ...) live alongside this in the real server
+// and aren't needed by this package.
+type Channels struct {
+	srv    *Server
+	cfgSvc configSvc
+
+	postActionCookieSecret []byte
+
+	clientConfig        atomic.Value // map[string]string
+	limitedClientConfig atomic.Value // map[string]string
+	clientConfigHash    atomic.Value // string
+
+	asymmetricSigningKey atomic.Value // *ecdsa.PrivateKey
+
+	// signingKeyRing and signingKeyRotationStop back the ECDSA key
+	// rotation ring added in signing_keys.go. signingKeyRotationOnce
+	// ensures StartSigningKeyRotation's goroutine is only launched once
+	// per Channels, no matter how often ensureSigningKeyRingStarted runs.
+	signingKeyRing         atomic.Value // *model.SigningKeyRing
+	signingKeyRotationStop chan struct{}
+	signingKeyRotationOnce sync.Once
+}
+
+// Shutdown stops background work this package started on ch, such as the
+// signing key rotation goroutine. The real Server.Shutdown calls this
+// alongside shutting down every other subsystem Channels owns.
+func (ch *Channels) Shutdown() {
+	ch.StopSigningKeyRotation()
+}
@@ -8,14 +8,18 @@ import (
 	"crypto/elliptic"
 	"crypto/md5"
 	"crypto/rand"
+	"crypto/sha256"
 	"crypto/x509"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"math/big"
 	"net/http"
 	"net/url"
 	"reflect"
 	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/pkg/errors"
@@ -36,6 +40,54 @@ const (
 type configWrapper struct {
 	srv *Server
 	*config.Store
+
+	// layered is populated when the server is configured with more than
+	// one ConfigSource (file/env/database/Consul/etcd). When set, it is
+	// consulted for provenance and owns routing writes to the correct
+	// layer; a nil layered means the legacy single-source Store above is
+	// authoritative, as it always has been.
+	layered *config.LayeredStore
+
+	// secrets resolves ${secret:<provider>://<path>#<field>} references
+	// in sensitive fields against Vault/AWS SM/GCP SM. A nil secrets means
+	// no resolver is configured and the config is used as stored.
+	secrets *config.SecretRegistry
+
+	// scopedListeners lets plugins (and other callers) register interest
+	// in a subset of config fields and be notified only of the diff
+	// entries they selected, rather than the full old/new config. See
+	// AddScopedConfigListener.
+	scopedListenersMu sync.Mutex
+	scopedListeners   map[string]scopedConfigListener
+
+	// configVersion is the highest version this node has applied, either
+	// from a local SaveConfig/SaveConfigWithETag or a cluster gossip
+	// message. It guards against applying a stale update that raced a
+	// newer one.
+	configVersion int64
+
+	// clusterConfigHandlerOnce registers handleClusterConfigMessage with
+	// the cluster exactly once, the first time a save needs to broadcast a
+	// versioned config.
+	clusterConfigHandlerOnce sync.Once
+
+	// configListenersMu/configListeners mirror the listener IDs registered
+	// through AddConfigListener so secretsRotated can fan a secret lease
+	// rotation out to them directly; w.Store's own listener list fires
+	// only on a persisted Set, which a rotation (deliberately) never does.
+	configListenersMu sync.Mutex
+	configListeners   map[string]func(*model.Config, *model.Config)
+
+	// secretsWatcherStop stops the SecretRegistry's lease watcher started
+	// by SetSecretRegistry, if any.
+	secretsWatcherStop chan struct{}
+
+	// casMu serializes SaveConfigWithETag's read-check-write sequence so
+	// two concurrent callers can't both read the same ETag, both pass the
+	// precondition, and race each other into SaveConfig. It is local to
+	// this node; a peer racing the same write is arbitrated by
+	// ApplyClusterConfig's version check instead.
+	casMu sync.Mutex
 }
 
 func (w *configWrapper) Name() ServiceKey {
@@ -43,15 +95,86 @@ func (w *configWrapper) Name() ServiceKey {
 }
 
 func (w *configWrapper) Config() *model.Config {
-	return w.Store.Get()
+	cfg := w.Store.Get()
+	if w.secrets == nil {
+		return cfg
+	}
+
+	resolved, err := w.secrets.Resolve(cfg)
+	if err != nil {
+		mlog.Error("Failed to resolve secret config references", mlog.Err(err))
+		return cfg
+	}
+	return resolved
 }
 
 func (w *configWrapper) AddConfigListener(listener func(*model.Config, *model.Config)) string {
-	return w.Store.AddListener(listener)
+	id := w.Store.AddListener(listener)
+
+	w.configListenersMu.Lock()
+	if w.configListeners == nil {
+		w.configListeners = make(map[string]func(*model.Config, *model.Config))
+	}
+	w.configListeners[id] = listener
+	w.configListenersMu.Unlock()
+
+	return id
 }
 
 func (w *configWrapper) RemoveConfigListener(id string) {
 	w.Store.RemoveListener(id)
+
+	w.configListenersMu.Lock()
+	delete(w.configListeners, id)
+	w.configListenersMu.Unlock()
+}
+
+// notifyConfigListeners fans (old, new) out to every listener registered
+// through AddConfigListener, the same listeners w.Store already notifies
+// on a persisted Set. It's used for updates that must NOT be persisted
+// back to the store, like a secret lease rotation resolving to a new
+// plaintext value in memory only.
+func (w *configWrapper) notifyConfigListeners(old, newCfg *model.Config) {
+	w.configListenersMu.Lock()
+	listeners := make([]func(*model.Config, *model.Config), 0, len(w.configListeners))
+	for _, l := range w.configListeners {
+		listeners = append(listeners, l)
+	}
+	w.configListenersMu.Unlock()
+
+	for _, l := range listeners {
+		l(old, newCfg)
+	}
+}
+
+// SetSecretRegistry attaches registry as the resolver for ${secret:...}
+// references in this store's configuration and wires its lease-expiry
+// notifications into the existing AddConfigListener pipeline, so a lease
+// expiring makes SMTP/SQL/etc. reconnect with the refreshed plaintext the
+// same way a manual config save already does. Passing a non-nil registry
+// a second time restarts its lease watcher.
+func (w *configWrapper) SetSecretRegistry(registry *config.SecretRegistry) {
+	if w.secretsWatcherStop != nil {
+		close(w.secretsWatcherStop)
+		w.secretsWatcherStop = nil
+	}
+
+	w.secrets = registry
+	if registry == nil {
+		return
+	}
+
+	registry.OnRotate(func(resolved *model.Config) {
+		old := w.Config()
+		w.notifyConfigListeners(old, resolved)
+	})
+
+	w.secretsWatcherStop = make(chan struct{})
+	registry.StartLeaseWatcher(w.secretsWatcherStop, w.Config)
+}
+
+func (a *App) SetSecretRegistry(registry *config.SecretRegistry) {
+	a.Srv().configStore.SetSecretRegistry(registry)
 }
 
 func (w *configWrapper) UpdateConfig(f func(*model.Config)) {
@@ -61,16 +184,39 @@ func (w *configWrapper) UpdateConfig(f func(*model.Config)) {
 	old := w.Config()
 	updated := old.Clone()
 	f(updated)
-	if _, _, err := w.Store.Set(updated); err != nil {
+	if _, _, err := w.Store.Set(w.redact(updated)); err != nil {
 		mlog.Error("Failed to update config", mlog.Err(err))
 	}
 }
 
+// redact rewrites any field in cfg that currently holds a resolved secret
+// plaintext back to its original ${secret:...} reference, so persisted
+// config never contains the decrypted value. It's a no-op when no
+// SecretRegistry is configured.
+func (w *configWrapper) redact(cfg *model.Config) *model.Config {
+	if w.secrets == nil {
+		return cfg
+	}
+	return w.secrets.Redact(cfg)
+}
+
 func (w *configWrapper) SaveConfig(newCfg *model.Config, sendConfigChangeClusterMessage bool) (*model.Config, *model.Config, *model.AppError) {
-	oldCfg, newCfg, err := w.Store.Set(newCfg)
-	if errors.Cause(err) == config.ErrReadOnlyConfiguration {
+	newCfg = w.redact(newCfg)
+
+	var oldCfg *model.Config
+	var err error
+	if w.layered != nil {
+		oldCfg, newCfg, err = w.layered.Set(newCfg)
+	} else {
+		oldCfg, newCfg, err = w.Store.Set(newCfg)
+	}
+
+	switch {
+	case errors.Cause(err) == config.ErrReadOnlyConfiguration:
 		return nil, nil, model.NewAppError("saveConfig", "ent.cluster.save_config.error", nil, err.Error(), http.StatusForbidden)
-	} else if err != nil {
+	case errors.Cause(err) == config.ErrUnownedKey:
+		return nil, nil, model.NewAppError("saveConfig", "app.save_config.unowned_layer.app_error", nil, err.Error(), http.StatusForbidden)
+	case err != nil:
 		return nil, nil, model.NewAppError("saveConfig", "app.save_config.app_error", nil, err.Error(), http.StatusInternalServerError)
 	}
 
@@ -83,17 +229,40 @@ func (w *configWrapper) SaveConfig(newCfg *model.Config, sendConfigChangeCluster
 		w.srv.StopMetricsServer()
 	}
 
+	atomic.AddInt64(&w.configVersion, 1)
+
 	if w.srv.Cluster != nil {
 		err := w.srv.Cluster.ConfigChanged(w.Store.RemoveEnvironmentOverrides(oldCfg),
 			w.Store.RemoveEnvironmentOverrides(newCfg), sendConfigChangeClusterMessage)
 		if err != nil {
 			return nil, nil, err
 		}
+		w.broadcastVersionedConfig(newCfg)
+	}
+
+	if diff, err := diffConfig(oldCfg, newCfg, w.Store.GetEnvironmentOverrides()); err == nil {
+		w.notifyScopedListeners(diff)
 	}
 
 	return oldCfg, newCfg, nil
 }
 
+// ConfigProvenance reports, for every field path touched by a layered
+// config source, which source last supplied its value. It returns nil when
+// the server is running with the legacy single-source store.
+func (w *configWrapper) ConfigProvenance() map[string]string {
+	if w.layered == nil {
+		return nil
+	}
+	return w.layered.Provenance()
+}
+
+// SecretRegistry returns the SecretRegistry resolving ${secret:...}
+// references for this store, or nil if none is configured.
+func (w *configWrapper) SecretRegistry() *config.SecretRegistry {
+	return w.secrets
+}
+
 func (w *configWrapper) ReloadConfig() error {
 	if err := w.Store.Load(); err != nil {
 		return err
@@ -133,6 +302,20 @@ func (s *Server) ReloadConfig() error {
 	return s.configStore.ReloadConfig()
 }
 
+// ConfigProvenance reports where each configuration field currently in
+// effect was sourced from, when the server is using a layered config
+// store. It returns nil otherwise.
+func (s *Server) ConfigProvenance() map[string]string {
+	return s.configStore.ConfigProvenance()
+}
+
+// ConfigProvenance reports where each configuration field currently in
+// effect was sourced from, when the server is using a layered config
+// store. It returns nil otherwise.
+func (a *App) ConfigProvenance() map[string]string {
+	return a.Srv().ConfigProvenance()
+}
+
 func (a *App) ReloadConfig() error {
 	return a.Srv().ReloadConfig()
 }
@@ -169,6 +352,38 @@ func (a *App) RemoveConfigListener(id string) {
 	a.Srv().RemoveConfigListener(id)
 }
 
+// systemSecretStore returns the SecretWriter/SecretResolver pair and base
+// path operators have opted into for storing server-generated secrets
+// (PostActionCookieSecret, AsymmetricSigningKey) via ServiceSettings's
+// SystemSecretsProvider, instead of the System table. ok is false when no
+// such provider is configured or registered, in which case callers fall
+// back to the System table as before.
+func (ch *Channels) systemSecretStore() (config.SecretWriter, config.SecretResolver, string, bool) {
+	secretSvc, ok := ch.cfgSvc.(interface{ SecretRegistry() *config.SecretRegistry })
+	if !ok {
+		return nil, nil, "", false
+	}
+	registry := secretSvc.SecretRegistry()
+	if registry == nil {
+		return nil, nil, "", false
+	}
+
+	provider := ch.cfgSvc.Config().ServiceSettings.SystemSecretsProvider
+	if provider == nil || *provider == "" {
+		return nil, nil, "", false
+	}
+
+	resolver, ok := registry.Resolver(*provider)
+	if !ok {
+		return nil, nil, "", false
+	}
+	writer, ok := resolver.(config.SecretWriter)
+	if !ok {
+		return nil, nil, "", false
+	}
+	return writer, resolver, "secret/data/mattermost-system", true
+}
+
 // ensurePostActionCookieSecret ensures that the key for encrypting PostActionCookie exists
 // and future calls to PostActionCookieSecret will always return a valid key, same on all
 // servers in the cluster
@@ -177,6 +392,15 @@ func (ch *Channels) ensurePostActionCookieSecret() error {
 		return nil
 	}
 
+	if writer, resolver, basePath, ok := ch.systemSecretStore(); ok {
+		secret, err := ensureSecretViaProvider(writer, resolver, basePath, "post-action-cookie-secret", "secret", 32)
+		if err != nil {
+			return err
+		}
+		ch.postActionCookieSecret = secret
+		return nil
+	}
+
 	var secret *model.SystemPostActionCookieSecret
 
 	value, err := ch.srv.Store.System().GetByName(model.SystemPostActionCookieSecretKey)
@@ -229,13 +453,50 @@ func (ch *Channels) ensurePostActionCookieSecret() error {
 	return nil
 }
 
+// ensureSecretViaProvider resolves a raw secret from path/field through an
+// already-registered SecretResolver, generating and persisting numBytes of
+// random material through writer the first time it's needed.
+func ensureSecretViaProvider(writer config.SecretWriter, resolver config.SecretResolver, basePath, name, field string, numBytes int) ([]byte, error) {
+	path := basePath + "/" + name
+
+	if raw, _, err := resolver.Resolve(path, field); err == nil && raw != "" {
+		return base64.StdEncoding.DecodeString(raw)
+	}
+
+	secret := make([]byte, numBytes)
+	if _, err := rand.Reader.Read(secret); err != nil {
+		return nil, err
+	}
+	if err := writer.WriteField(path, field, base64.StdEncoding.EncodeToString(secret)); err != nil {
+		return nil, errors.Wrap(err, "failed to persist generated secret")
+	}
+	return secret, nil
+}
+
 // ensureAsymmetricSigningKey ensures that an asymmetric signing key exists and future calls to
 // AsymmetricSigningKey will always return a valid signing key.
 func (ch *Channels) ensureAsymmetricSigningKey() error {
+	defer ch.ensureSigningKeyRingStarted()
+
 	if ch.AsymmetricSigningKey() != nil {
 		return nil
 	}
 
+	if writer, resolver, basePath, ok := ch.systemSecretStore(); ok {
+		d, err := ensureSecretViaProvider(writer, resolver, basePath, "asymmetric-signing-key", "d", 32)
+		if err != nil {
+			return err
+		}
+		curve := elliptic.P256()
+		x, y := curve.ScalarBaseMult(d)
+		ch.asymmetricSigningKey.Store(&ecdsa.PrivateKey{
+			PublicKey: ecdsa.PublicKey{Curve: curve, X: x, Y: y},
+			D:         new(big.Int).SetBytes(d),
+		})
+		ch.regenerateClientConfig()
+		return nil
+	}
+
 	var key *model.SystemAsymmetricSigningKey
 
 	value, err := ch.srv.Store.System().GetByName(model.SystemAsymmetricSigningKeyKey)
@@ -345,8 +606,17 @@ func (s *Server) ensureFirstServerRunTimestamp() error {
 	return nil
 }
 
-// AsymmetricSigningKey will return a private key that can be used for asymmetric signing.
+// AsymmetricSigningKey will return a private key that can be used for
+// asymmetric signing. Once the signing key ring has been populated, it
+// returns the ring's current key (so new signatures rotate along with it
+// and stamp a kid verifiers can look up); otherwise it falls back to the
+// single legacy key ensureAsymmetricSigningKey provisions.
 func (ch *Channels) AsymmetricSigningKey() *ecdsa.PrivateKey {
+	if kid := ch.currentSigningKid(); kid != "" {
+		if key, ok := ch.SigningKeyByKid(kid); ok {
+			return key
+		}
+	}
 	if key := ch.asymmetricSigningKey.Load(); key != nil {
 		return key.(*ecdsa.PrivateKey)
 	}
@@ -385,12 +655,63 @@ func (ch *Channels) regenerateClientConfig() {
 		limitedClientConfig["AsymmetricSigningPublicKey"] = base64.StdEncoding.EncodeToString(der)
 	}
 
+	// Publish the full JWKS so clients can verify tokens signed by any
+	// key still active in the rotation, not just the newest one.
+	// AsymmetricSigningPublicKey above is kept for older clients that
+	// don't understand JWKS yet.
+	if jwks, appErr := ch.JWKS(); appErr == nil {
+		if jwksJSON, err := json.Marshal(jwks); err == nil {
+			clientConfig["AsymmetricSigningPublicKeys"] = string(jwksJSON)
+			limitedClientConfig["AsymmetricSigningPublicKeys"] = string(jwksJSON)
+		}
+	}
+
+	// Sign the payload so clients can verify it hasn't been tampered with
+	// in transit; the public key published above is what they verify
+	// against. Stamp which kid signed it so a client that's already
+	// fetched the JWKS can pick the matching key straight away instead of
+	// trying every key in it.
+	// Each map is signed over its own contents, before its signature and
+	// kid are added.
+	for _, cfg := range []map[string]string{clientConfig, limitedClientConfig} {
+		if sig, err := signClientConfig(ch.AsymmetricSigningKey(), cfg); err != nil {
+			mlog.Warn("Failed to sign client config", mlog.Err(err))
+		} else if sig != "" {
+			cfg["AsymmetricSigningSignature"] = sig
+			if kid := ch.currentSigningKid(); kid != "" {
+				cfg["AsymmetricSigningKid"] = kid
+			}
+		}
+	}
+
 	clientConfigJSON, _ := json.Marshal(clientConfig)
 	ch.clientConfig.Store(clientConfig)
 	ch.limitedClientConfig.Store(limitedClientConfig)
 	ch.clientConfigHash.Store(fmt.Sprintf("%x", md5.Sum(clientConfigJSON)))
 }
 
+// signClientConfig computes an ECDSA signature over the canonical JSON
+// encoding of cfg using key, returning it base64-encoded. It returns an
+// empty string without error when key is nil, since signing is only
+// possible once the asymmetric signing key has been provisioned.
+func signClientConfig(key *ecdsa.PrivateKey, cfg map[string]string) (string, error) {
+	if key == nil {
+		return "", nil
+	}
+
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to marshal client config for signing")
+	}
+
+	digest := sha256.Sum256(data)
+	sig, err := ecdsa.SignASN1(rand.Reader, key, digest[:])
+	if err != nil {
+		return "", errors.Wrap(err, "failed to sign client config")
+	}
+	return base64.StdEncoding.EncodeToString(sig), nil
+}
+
 func (a *App) GetCookieDomain() string {
 	if *a.Config().ServiceSettings.AllowCookiesForSubdomains {
 		if siteURL, err := url.Parse(*a.Config().ServiceSettings.SiteURL); err == nil {
@@ -489,6 +810,42 @@ func (a *App) HandleMessageExportConfig(cfg *model.Config, appCfg *model.Config)
 	}
 }
 
+// MigrateSecretsToProvider writes the in-memory PostActionCookieSecret and
+// AsymmetricSigningKey to the named secret provider (as registered on the
+// config store's SecretRegistry), backing `mmctl config migrate-secrets`.
+// It does not delete the System table rows or change the in-memory
+// values; ensurePostActionCookieSecret and ensureAsymmetricSigningKey read
+// the provider copies once ServiceSettings.SystemSecretsProvider names it.
+func (a *App) MigrateSecretsToProvider(provider string) *model.AppError {
+	registry := a.Srv().ConfigStore().SecretRegistry()
+	if registry == nil {
+		return model.NewAppError("MigrateSecretsToProvider", "app.migrate_secrets.no_registry.app_error", nil, "", http.StatusNotImplemented)
+	}
+
+	resolver, ok := registry.Resolver(provider)
+	if !ok {
+		return model.NewAppError("MigrateSecretsToProvider", "app.migrate_secrets.unknown_provider.app_error", nil, "provider="+provider, http.StatusBadRequest)
+	}
+	writer, ok := resolver.(config.SecretWriter)
+	if !ok {
+		return model.NewAppError("MigrateSecretsToProvider", "app.migrate_secrets.not_writable.app_error", nil, "provider="+provider, http.StatusBadRequest)
+	}
+
+	const basePath = "secret/data/mattermost-system"
+	if secret := a.PostActionCookieSecret(); secret != nil {
+		if err := writer.WriteField(basePath+"/post-action-cookie-secret", "secret", base64.StdEncoding.EncodeToString(secret)); err != nil {
+			return model.NewAppError("MigrateSecretsToProvider", "app.migrate_secrets.write_failed.app_error", nil, err.Error(), http.StatusInternalServerError)
+		}
+	}
+	if key := a.AsymmetricSigningKey(); key != nil {
+		if err := writer.WriteField(basePath+"/asymmetric-signing-key", "d", base64.StdEncoding.EncodeToString(key.D.Bytes())); err != nil {
+			return model.NewAppError("MigrateSecretsToProvider", "app.migrate_secrets.write_failed.app_error", nil, err.Error(), http.StatusInternalServerError)
+		}
+	}
+
+	return nil
+}
+
 func (s *Server) MailServiceConfig() *mail.SMTPConfig {
 	emailSettings := s.Config().EmailSettings
 	hostname := utils.GetHostnameFromSiteURL(*s.Config().ServiceSettings.SiteURL)
@@ -0,0 +1,252 @@
+// Copyright (c) 2015-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package app
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"github.com/mattermost/mattermost-server/v6/config"
+	"github.com/mattermost/mattermost-server/v6/model"
+	"github.com/mattermost/mattermost-server/v6/shared/mlog"
+)
+
+// SaveConfigWithETag is the compare-and-swap variant of SaveConfig: it
+// only writes newCfg if the stored configuration's current ETag still
+// matches ifMatch, letting the REST layer implement If-Match semantics
+// and refuse to silently clobber a concurrent write from another admin or
+// cluster node. An empty ifMatch skips the precondition, matching the
+// unconditional behavior of SaveConfig.
+//
+// The underlying config.Store predates ETags and has no ifMatch parameter
+// on Set, so the precondition is enforced here instead: w.casMu is held
+// across the whole read-check-write sequence, so the ETag checked against
+// ifMatch can't go stale between the check and SaveConfig actually
+// touching the store. A second node racing the same write goes through
+// ApplyClusterConfig's version check instead.
+func (w *configWrapper) SaveConfigWithETag(newCfg *model.Config, ifMatch string, sendConfigChangeClusterMessage bool) (*model.Config, *model.Config, *model.AppError) {
+	w.casMu.Lock()
+	defer w.casMu.Unlock()
+
+	if ifMatch != "" {
+		currentETag, err := w.ConfigETag()
+		if err != nil {
+			return nil, nil, model.NewAppError("saveConfig", "app.save_config.app_error", nil, err.Error(), http.StatusInternalServerError)
+		}
+		if currentETag != ifMatch {
+			return nil, nil, model.NewAppError("saveConfig", "app.save_config.precondition_failed.app_error", nil, config.ErrPreconditionFailed.Error(), http.StatusConflict)
+		}
+	}
+
+	return w.SaveConfig(newCfg, sendConfigChangeClusterMessage)
+}
+
+func (a *App) SaveConfigWithETag(newCfg *model.Config, ifMatch string, sendConfigChangeClusterMessage bool) (*model.Config, *model.Config, *model.AppError) {
+	return a.Srv().configStore.SaveConfigWithETag(newCfg, ifMatch, sendConfigChangeClusterMessage)
+}
+
+// ConfigETag returns the ETag of the currently active configuration, for
+// REST responses to surface as an If-Match precondition.
+func (w *configWrapper) ConfigETag() (string, error) {
+	return config.ComputeETag(w.Config())
+}
+
+func (a *App) ConfigETag() (string, error) {
+	return a.Srv().configStore.ConfigETag()
+}
+
+// ApplyClusterConfig is invoked when this node receives a versioned config
+// message from a peer (see broadcastVersionedConfig/
+// handleClusterConfigMessage). It compares the incoming version against the
+// highest version this node has applied and only accepts it if it's newer,
+// so two nodes racing a reload can't apply updates out of order. It returns
+// false (NACK) when the incoming version is stale.
+func (w *configWrapper) ApplyClusterConfig(cfg *model.Config, version int64) bool {
+	for {
+		current := atomic.LoadInt64(&w.configVersion)
+		if version <= current {
+			return false
+		}
+		if atomic.CompareAndSwapInt64(&w.configVersion, current, version) {
+			break
+		}
+	}
+
+	if w.layered != nil {
+		if _, _, err := w.layered.Set(cfg); err != nil {
+			mlog.Warn("Failed to apply cluster config update", mlog.Err(err))
+			return false
+		}
+		return true
+	}
+
+	if _, _, err := w.Store.Set(cfg); err != nil {
+		mlog.Warn("Failed to apply cluster config update", mlog.Err(err))
+		return false
+	}
+	return true
+}
+
+// registerClusterConfigHandler wires handleClusterConfigMessage into the
+// cluster once, the first time a save needs to broadcast a versioned
+// config. It's idempotent and cheap to call from every SaveConfig.
+func (w *configWrapper) registerClusterConfigHandler() {
+	if w.srv.Cluster == nil {
+		return
+	}
+	w.clusterConfigHandlerOnce.Do(func() {
+		w.srv.Cluster.RegisterClusterMessageHandler(model.ClusterEventConfigVersioned, w.handleClusterConfigMessage)
+	})
+}
+
+// broadcastVersionedConfig sends cfg and the version SaveConfig just
+// committed it under to every cluster peer, so ApplyClusterConfig has
+// something to arbitrate against instead of the existing
+// ClusterInterface.ConfigChanged message, which carries no version.
+func (w *configWrapper) broadcastVersionedConfig(cfg *model.Config) {
+	w.registerClusterConfigHandler()
+
+	data, err := json.Marshal(config.VersionedConfig{
+		Config:  cfg,
+		Version: atomic.LoadInt64(&w.configVersion),
+	})
+	if err != nil {
+		mlog.Warn("Failed to marshal versioned config for cluster broadcast", mlog.Err(err))
+		return
+	}
+
+	w.srv.Cluster.SendClusterMessage(&model.ClusterMessage{
+		Event:    model.ClusterEventConfigVersioned,
+		SendType: model.ClusterSendReliable,
+		Data:     data,
+	})
+}
+
+// handleClusterConfigMessage applies a versioned config gossiped by a peer
+// via broadcastVersionedConfig.
+func (w *configWrapper) handleClusterConfigMessage(msg *model.ClusterMessage) {
+	var versioned config.VersionedConfig
+	if err := json.Unmarshal(msg.Data, &versioned); err != nil {
+		mlog.Warn("Failed to decode cluster config message", mlog.Err(err))
+		return
+	}
+	if !w.ApplyClusterConfig(versioned.Config, versioned.Version) {
+		mlog.Debug("Ignored stale or out-of-order cluster config update", mlog.Int64("version", versioned.Version))
+	}
+}
+
+// pathValue walks a dot-separated field path through a JSON-shaped map,
+// returning the leaf value and whether every segment along the way
+// existed.
+func pathValue(m map[string]interface{}, path string) (interface{}, bool) {
+	segments := strings.Split(path, ".")
+	cur := interface{}(m)
+	for _, seg := range segments {
+		asMap, ok := cur.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		cur, ok = asMap[seg]
+		if !ok {
+			return nil, false
+		}
+	}
+	return cur, true
+}
+
+// setPathValue writes value at a dot-separated field path in a
+// JSON-shaped map, creating intermediate maps as needed.
+func setPathValue(m map[string]interface{}, path string, value interface{}) {
+	segments := strings.Split(path, ".")
+	cur := m
+	for _, seg := range segments[:len(segments)-1] {
+		child, ok := cur[seg].(map[string]interface{})
+		if !ok {
+			child = map[string]interface{}{}
+			cur[seg] = child
+		}
+		cur = child
+	}
+	cur[segments[len(segments)-1]] = value
+}
+
+// ThreeWayMergeConfig applies every field baseline->ours changed onto
+// theirs, the way `mmctl config edit` reconciles a local edit against
+// whatever the server holds after a failed compare-and-swap. It returns
+// the merged config and the list of field paths both sides changed
+// differently, which the caller (mmctl) should surface to the user rather
+// than silently pick a winner for.
+func ThreeWayMergeConfig(baseline, ours, theirs *model.Config) (*model.Config, []string, error) {
+	baseVsOurs, err := diffConfig(baseline, ours, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	theirsMap, err := toJSONMap(theirs)
+	if err != nil {
+		return nil, nil, err
+	}
+	oursMap, err := toJSONMap(ours)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var conflicts []string
+	for _, change := range baseVsOurs.Changes {
+		theirsVal, _ := pathValue(theirsMap, change.Path)
+		if !valuesEqual(theirsVal, change.OldValue) && !valuesEqual(theirsVal, change.NewValue) {
+			conflicts = append(conflicts, change.Path)
+			continue
+		}
+		ourVal, _ := pathValue(oursMap, change.Path)
+		setPathValue(theirsMap, change.Path, ourVal)
+	}
+
+	data, err := json.Marshal(theirsMap)
+	if err != nil {
+		return nil, nil, err
+	}
+	merged := &model.Config{}
+	if err := json.Unmarshal(data, merged); err != nil {
+		return nil, nil, err
+	}
+	return merged, conflicts, nil
+}
+
+// ConfigEditApply is the single entry point `mmctl config edit` calls: it
+// attempts the operator's edited config with an If-Match precondition, and
+// if another write raced it, three-way merges the edit against whatever's
+// live now instead of failing outright. conflicts is non-empty when the
+// merge found fields both sides changed differently; the caller should
+// surface those to the operator rather than silently picking a winner.
+func (a *App) ConfigEditApply(baseline, ours *model.Config, ifMatch string, sendConfigChangeClusterMessage bool) (saved *model.Config, conflicts []string, appErr *model.AppError) {
+	_, saved, appErr = a.SaveConfigWithETag(ours, ifMatch, sendConfigChangeClusterMessage)
+	if appErr == nil {
+		return saved, nil, nil
+	}
+	if appErr.StatusCode != http.StatusConflict {
+		return nil, nil, appErr
+	}
+
+	theirs := a.Config()
+	merged, conflicts, err := ThreeWayMergeConfig(baseline, ours, theirs)
+	if err != nil {
+		return nil, nil, model.NewAppError("ConfigEditApply", "app.config_edit.merge_failed.app_error", nil, err.Error(), http.StatusInternalServerError)
+	}
+	if len(conflicts) > 0 {
+		return nil, conflicts, model.NewAppError("ConfigEditApply", "app.config_edit.conflict.app_error", nil, "", http.StatusConflict)
+	}
+
+	theirsETag, err := a.ConfigETag()
+	if err != nil {
+		return nil, nil, model.NewAppError("ConfigEditApply", "app.config_edit.merge_failed.app_error", nil, err.Error(), http.StatusInternalServerError)
+	}
+	_, saved, appErr = a.SaveConfigWithETag(merged, theirsETag, sendConfigChangeClusterMessage)
+	if appErr != nil {
+		return nil, nil, appErr
+	}
+	return saved, nil, nil
+}
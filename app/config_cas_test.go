@@ -0,0 +1,37 @@
+// Copyright (c) 2015-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package app
+
+import "testing"
+
+func TestPathValue(t *testing.T) {
+	m := map[string]interface{}{
+		"ServiceSettings": map[string]interface{}{
+			"SiteURL": "https://example.com",
+		},
+	}
+
+	if v, ok := pathValue(m, "ServiceSettings.SiteURL"); !ok || v != "https://example.com" {
+		t.Fatalf("expected to find SiteURL, got %v, %v", v, ok)
+	}
+	if _, ok := pathValue(m, "ServiceSettings.Missing"); ok {
+		t.Fatal("expected Missing to be absent")
+	}
+	if _, ok := pathValue(m, "ServiceSettings.SiteURL.Extra"); ok {
+		t.Fatal("expected descending into a non-map leaf to fail")
+	}
+}
+
+func TestSetPathValue(t *testing.T) {
+	m := map[string]interface{}{}
+	setPathValue(m, "ServiceSettings.SiteURL", "https://example.com")
+
+	svc, ok := m["ServiceSettings"].(map[string]interface{})
+	if !ok {
+		t.Fatalf("expected ServiceSettings to be created as a map, got %T", m["ServiceSettings"])
+	}
+	if svc["SiteURL"] != "https://example.com" {
+		t.Fatalf("expected SiteURL to be set, got %v", svc["SiteURL"])
+	}
+}
@@ -0,0 +1,250 @@
+// Copyright (c) 2015-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package app
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/mattermost/mattermost-server/v6/model"
+)
+
+// restartEffectsByPrefix maps a config field path prefix to the
+// human-readable side effect SaveConfig would trigger for it, so a diff
+// can tell an operator what applying it actually does before they commit.
+// Prefixes are checked longest-match-first.
+var restartEffectsByPrefix = map[string]string{
+	"EmailSettings":           "SMTP reconnect",
+	"MetricsSettings":         "metrics server restart",
+	"ClusterSettings":         "cluster rebroadcast",
+	"PluginSettings":          "plugin reinit",
+	"SqlSettings":             "database reconnect",
+	"FileSettings":            "file backend reinit",
+	"ServiceSettings.SiteURL": "cluster rebroadcast",
+}
+
+// restartEffectFor returns the side effect applying a change at path would
+// trigger, or "" if the field is expected to apply without one.
+func restartEffectFor(path string) string {
+	best := ""
+	for prefix := range restartEffectsByPrefix {
+		if (path == prefix || strings.HasPrefix(path, prefix+".")) && len(prefix) > len(best) {
+			best = prefix
+		}
+	}
+	if best == "" {
+		return ""
+	}
+	return restartEffectsByPrefix[best]
+}
+
+// diffConfig walks old and new's JSON representations and returns every
+// leaf field path whose value differs, along with the old/new values and
+// whether it's currently pinned by an environment variable override.
+func diffConfig(old, newCfg *model.Config, envOverrides map[string]interface{}) (*model.ConfigDiff, error) {
+	oldMap, err := toJSONMap(old)
+	if err != nil {
+		return nil, err
+	}
+	newMap, err := toJSONMap(newCfg)
+	if err != nil {
+		return nil, err
+	}
+
+	diff := &model.ConfigDiff{}
+	collectDiff(oldMap, newMap, "", envOverrides, diff)
+	return diff, nil
+}
+
+func toJSONMap(cfg *model.Config) (map[string]interface{}, error) {
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		return nil, err
+	}
+	m := map[string]interface{}{}
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func collectDiff(oldMap, newMap map[string]interface{}, prefix string, envOverrides map[string]interface{}, diff *model.ConfigDiff) {
+	seen := make(map[string]bool, len(oldMap)+len(newMap))
+	for k := range oldMap {
+		seen[k] = true
+	}
+	for k := range newMap {
+		seen[k] = true
+	}
+
+	for k := range seen {
+		path := k
+		if prefix != "" {
+			path = prefix + "." + k
+		}
+
+		oldVal, oldOK := oldMap[k]
+		newVal, newOK := newMap[k]
+
+		oldChild, oldIsMap := oldVal.(map[string]interface{})
+		newChild, newIsMap := newVal.(map[string]interface{})
+		if oldIsMap || newIsMap {
+			if !oldIsMap {
+				oldChild = map[string]interface{}{}
+			}
+			if !newIsMap {
+				newChild = map[string]interface{}{}
+			}
+			collectDiff(oldChild, newChild, path, envOverrides, diff)
+			continue
+		}
+
+		if oldOK && newOK && valuesEqual(oldVal, newVal) {
+			continue
+		}
+
+		_, envOverridden := pathValue(envOverrides, path)
+		diff.Changes = append(diff.Changes, model.ConfigDiffEntry{
+			Path:         path,
+			OldValue:     oldVal,
+			NewValue:     newVal,
+			EnvOverride:  envOverridden,
+			RestartEffect: restartEffectFor(path),
+		})
+	}
+}
+
+func valuesEqual(a, b interface{}) bool {
+	aJSON, _ := json.Marshal(a)
+	bJSON, _ := json.Marshal(b)
+	return string(aJSON) == string(bJSON)
+}
+
+// ValidateConfig computes a structured diff between the active
+// configuration and newCfg without persisting anything, reporting which
+// fields would change, whether they're currently environment-overridden,
+// and what side effect (if any) saving them would trigger.
+func (w *configWrapper) ValidateConfig(newCfg *model.Config) (*model.ConfigDiff, *model.AppError) {
+	envOverrides := w.Store.GetEnvironmentOverrides()
+	diff, err := diffConfig(w.Config(), newCfg, envOverrides)
+	if err != nil {
+		return nil, model.NewAppError("ValidateConfig", "app.validate_config.app_error", nil, err.Error(), http.StatusInternalServerError)
+	}
+	return diff, nil
+}
+
+func (a *App) ValidateConfig(newCfg *model.Config) (*model.ConfigDiff, *model.AppError) {
+	return a.Srv().configStore.ValidateConfig(newCfg)
+}
+
+// DryRunSaveConfigOptions controls what DryRunSaveConfig reports.
+type DryRunSaveConfigOptions struct {
+	// IncludeEnvOverrides includes fields pinned by an environment
+	// variable in the diff even though saving them would have no effect.
+	IncludeEnvOverrides bool
+}
+
+// DryRunSaveConfig behaves like SaveConfig but never writes newCfg to the
+// store or broadcasts it to the cluster; it only reports what would
+// happen, backing the System Console's "preview changes" flow.
+func (w *configWrapper) DryRunSaveConfig(newCfg *model.Config, opts DryRunSaveConfigOptions) (*model.ConfigDiff, *model.AppError) {
+	diff, appErr := w.ValidateConfig(newCfg)
+	if appErr != nil {
+		return nil, appErr
+	}
+
+	if !opts.IncludeEnvOverrides {
+		filtered := diff.Changes[:0]
+		for _, entry := range diff.Changes {
+			if !entry.EnvOverride {
+				filtered = append(filtered, entry)
+			}
+		}
+		diff.Changes = filtered
+	}
+	return diff, nil
+}
+
+func (a *App) DryRunSaveConfig(newCfg *model.Config, opts DryRunSaveConfigOptions) (*model.ConfigDiff, *model.AppError) {
+	return a.Srv().configStore.DryRunSaveConfig(newCfg, opts)
+}
+
+// SaveConfigWithDiff saves newCfg and returns the structured diff of what
+// actually changed (the pre-save config against the post-save one), for
+// callers that want both the commit and a report of its effect in one
+// call. It intentionally takes no http.Request/ResponseWriter: wiring this
+// up as a REST endpoint requires the same session + PermissionSysconsole*
+// gating every other config-mutating route goes through, which belongs in
+// api4 alongside that middleware, not as a bare handler in this package.
+func (a *App) SaveConfigWithDiff(newCfg *model.Config, sendConfigChangeClusterMessage bool) (*model.ConfigDiff, *model.AppError) {
+	envOverrides := a.Srv().configStore.Store.GetEnvironmentOverrides()
+	oldCfg, savedCfg, appErr := a.SaveConfig(newCfg, sendConfigChangeClusterMessage)
+	if appErr != nil {
+		return nil, appErr
+	}
+
+	diff, err := diffConfig(oldCfg, savedCfg, envOverrides)
+	if err != nil {
+		return nil, model.NewAppError("SaveConfigWithDiff", "app.save_config.app_error", nil, err.Error(), http.StatusInternalServerError)
+	}
+	return diff, nil
+}
+
+// ConfigDiffSelector reports whether a plugin cares about a given changed
+// field path, so it can register interest in a subset of the config
+// instead of receiving the full old/new config on every change.
+type ConfigDiffSelector func(path string) bool
+
+type scopedConfigListener struct {
+	selector ConfigDiffSelector
+	listener func(*model.ConfigDiff)
+}
+
+// AddScopedConfigListener registers listener to be called with only the
+// ConfigDiffEntry values selector matches, whenever SaveConfig succeeds.
+// It returns an ID that can later be passed to RemoveScopedConfigListener.
+func (w *configWrapper) AddScopedConfigListener(selector ConfigDiffSelector, listener func(*model.ConfigDiff)) string {
+	w.scopedListenersMu.Lock()
+	defer w.scopedListenersMu.Unlock()
+
+	if w.scopedListeners == nil {
+		w.scopedListeners = make(map[string]scopedConfigListener)
+	}
+	id := model.NewId()
+	w.scopedListeners[id] = scopedConfigListener{selector: selector, listener: listener}
+	return id
+}
+
+// RemoveScopedConfigListener removes a listener registered with
+// AddScopedConfigListener.
+func (w *configWrapper) RemoveScopedConfigListener(id string) {
+	w.scopedListenersMu.Lock()
+	defer w.scopedListenersMu.Unlock()
+	delete(w.scopedListeners, id)
+}
+
+// notifyScopedListeners filters diff down to what each registered scoped
+// listener selected and invokes it, so plugins only see the fields they
+// asked about.
+func (w *configWrapper) notifyScopedListeners(diff *model.ConfigDiff) {
+	w.scopedListenersMu.Lock()
+	listeners := make([]scopedConfigListener, 0, len(w.scopedListeners))
+	for _, l := range w.scopedListeners {
+		listeners = append(listeners, l)
+	}
+	w.scopedListenersMu.Unlock()
+
+	for _, l := range listeners {
+		scoped := &model.ConfigDiff{}
+		for _, entry := range diff.Changes {
+			if l.selector(entry.Path) {
+				scoped.Changes = append(scoped.Changes, entry)
+			}
+		}
+		if len(scoped.Changes) > 0 {
+			l.listener(scoped)
+		}
+	}
+}
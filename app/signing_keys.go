@@ -0,0 +1,393 @@
+// Copyright (c) 2015-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package app
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"time"
+
+	"github.com/mattermost/mattermost-server/v6/model"
+	"github.com/mattermost/mattermost-server/v6/shared/mlog"
+)
+
+// defaultSigningKeyRingSize is how many keys are kept in rotation when
+// ServiceSettings.SigningKeyRotationSize is unset.
+const defaultSigningKeyRingSize = 3
+
+// signingKeyGenerationSystemKey stores a monotonic counter used to let
+// exactly one node in the cluster win the race to generate the next
+// signing key, the same claim pattern ensureAsymmetricSigningKey already
+// uses for the single-key case.
+const signingKeyGenerationSystemKey = "SigningKeyRingGeneration"
+
+// ensureSigningKeyRingStarted makes sure the signing key ring has been
+// populated and its background rotation goroutine started. It's wired in
+// as a side effect of ensureAsymmetricSigningKey, the one call every
+// startup path already makes before the legacy single key is used, so the
+// ring comes up the same place the key it's replacing does. It's safe to
+// call repeatedly; the rotation goroutine is only started once per
+// Channels.
+func (ch *Channels) ensureSigningKeyRingStarted() {
+	if err := ch.ensureSigningKeyRing(); err != nil {
+		mlog.Error("Failed to ensure signing key ring", mlog.Err(err))
+		return
+	}
+	ch.signingKeyRotationOnce.Do(ch.StartSigningKeyRotation)
+}
+
+// ensureSigningKeyRing ensures the signing key ring holds at least one
+// active key, generating the ring's first key if none has been persisted
+// yet.
+func (ch *Channels) ensureSigningKeyRing() error {
+	if ring := ch.loadSigningKeyRing(); ring != nil && len(ring.Keys) > 0 {
+		return nil
+	}
+
+	ring, err := ch.loadSigningKeyRingFromStore()
+	if err != nil {
+		return err
+	}
+
+	if ring == nil || len(ring.Keys) == 0 {
+		entry, genErr := newSigningKeyRingEntry()
+		if genErr != nil {
+			return genErr
+		}
+		ring = &model.SigningKeyRing{Keys: []*model.SigningKeyRingEntry{entry}}
+
+		if err := ch.saveSigningKeyRing(ring); err != nil {
+			// Another node may have beaten us to it; re-read rather than
+			// failing startup.
+			mlog.Warn("Failed to save new signing key ring, re-reading", mlog.Err(err))
+			ring, err = ch.loadSigningKeyRingFromStore()
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	ch.storeSigningKeyRing(ring)
+	ch.regenerateClientConfig()
+	return nil
+}
+
+func (ch *Channels) loadSigningKeyRingFromStore() (*model.SigningKeyRing, error) {
+	value, err := ch.srv.Store.System().GetByName(model.SystemAsymmetricSigningKeyRingKey)
+	if err != nil {
+		return nil, nil
+	}
+
+	ring := &model.SigningKeyRing{}
+	if err := json.Unmarshal([]byte(value.Value), ring); err != nil {
+		return nil, err
+	}
+	return ring, nil
+}
+
+func (ch *Channels) saveSigningKeyRing(ring *model.SigningKeyRing) error {
+	data, err := json.Marshal(ring)
+	if err != nil {
+		return err
+	}
+	return ch.srv.Store.System().Save(&model.System{
+		Name:  model.SystemAsymmetricSigningKeyRingKey,
+		Value: string(data),
+	})
+}
+
+func newSigningKeyRingEntry() (*model.SigningKeyRingEntry, error) {
+	ecdsaKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	now := model.GetMillis()
+	return &model.SigningKeyRingEntry{
+		Kid:       model.NewId(),
+		CreatedAt: now,
+		NotBefore: now,
+		ECDSAKey: &model.SystemECDSAKey{
+			Curve: "P-256",
+			X:     ecdsaKey.X,
+			Y:     ecdsaKey.Y,
+			D:     ecdsaKey.D,
+		},
+	}, nil
+}
+
+// RotateSigningKey claims the next generation of the signing key ring
+// (via an atomic SaveOrUpdate on the System table so only one cluster node
+// wins the race), prepends a freshly generated key, retires any keys past
+// the ring size, and broadcasts the rotation to peers so they reload
+// immediately instead of waiting for their next config reload.
+func (ch *Channels) RotateSigningKey() error {
+	claimed, err := ch.claimSigningKeyGeneration()
+	if err != nil {
+		return err
+	}
+	if !claimed {
+		// Another node already rotated for this generation; pick up its
+		// result from that node's cluster broadcast.
+		return nil
+	}
+
+	ring, err := ch.loadSigningKeyRingFromStore()
+	if err != nil {
+		return err
+	}
+	if ring == nil {
+		ring = &model.SigningKeyRing{}
+	}
+
+	entry, err := newSigningKeyRingEntry()
+	if err != nil {
+		return err
+	}
+
+	keys := append([]*model.SigningKeyRingEntry{entry}, ring.Keys...)
+	size := defaultSigningKeyRingSize
+	if ch.cfgSvc.Config().ServiceSettings.SigningKeyRotationSize != nil {
+		size = *ch.cfgSvc.Config().ServiceSettings.SigningKeyRotationSize
+	}
+	now := model.GetMillis()
+	for i, k := range keys {
+		if i >= size && k.RetiredAt == 0 {
+			k.RetiredAt = now
+		}
+	}
+	ring.Keys = keys
+
+	if err := ch.saveSigningKeyRing(ring); err != nil {
+		return err
+	}
+	ch.storeSigningKeyRing(ring)
+	ch.regenerateClientConfig()
+
+	if ch.srv.Cluster != nil {
+		ch.srv.Cluster.SendClusterMessage(&model.ClusterMessage{
+			Event:    model.ClusterEventSigningKeyRotated,
+			SendType: model.ClusterSendReliable,
+		})
+	}
+	return nil
+}
+
+// claimSigningKeyGeneration increments the shared generation counter and
+// reports whether this call was the one that advanced it, giving exactly
+// one node in the cluster the right to generate the next key.
+func (ch *Channels) claimSigningKeyGeneration() (bool, error) {
+	value, err := ch.srv.Store.System().GetByName(signingKeyGenerationSystemKey)
+	var current int64
+	if err == nil {
+		fmt.Sscanf(value.Value, "%d", &current)
+	}
+
+	next := current + 1
+	system := &model.System{
+		Name:  signingKeyGenerationSystemKey,
+		Value: fmt.Sprintf("%d", next),
+	}
+	if err := ch.srv.Store.System().SaveOrUpdate(system); err != nil {
+		return false, err
+	}
+
+	// Re-read to detect a concurrent winner: if the stored value no
+	// longer matches what we wrote, another node claimed this generation
+	// first.
+	confirm, err := ch.srv.Store.System().GetByName(signingKeyGenerationSystemKey)
+	if err != nil {
+		return false, err
+	}
+	return confirm.Value == system.Value, nil
+}
+
+// StartSigningKeyRotation launches a background goroutine that calls
+// RotateSigningKey on the interval configured by
+// ServiceSettings.SigningKeyRotationInterval. Call StopSigningKeyRotation
+// to stop it during shutdown.
+func (ch *Channels) StartSigningKeyRotation() {
+	interval := 24 * time.Hour
+	if d := ch.cfgSvc.Config().ServiceSettings.SigningKeyRotationInterval; d != nil && *d > 0 {
+		interval = time.Duration(*d) * time.Second
+	}
+
+	stop := make(chan struct{})
+	ch.signingKeyRotationStop = stop
+
+	if ch.srv.Cluster != nil {
+		// Reload the ring a peer's RotateSigningKey just saved.
+		ch.srv.Cluster.RegisterClusterMessageHandler(model.ClusterEventSigningKeyRotated, func(*model.ClusterMessage) {
+			ring, err := ch.loadSigningKeyRingFromStore()
+			if err != nil || ring == nil {
+				mlog.Warn("Failed to reload rotated signing key ring", mlog.Err(err))
+				return
+			}
+			ch.storeSigningKeyRing(ring)
+			ch.regenerateClientConfig()
+		})
+	}
+
+	ticker := time.NewTicker(interval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := ch.RotateSigningKey(); err != nil {
+					mlog.Error("Failed to rotate signing key", mlog.Err(err))
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// StopSigningKeyRotation stops the goroutine started by
+// StartSigningKeyRotation, if any.
+func (ch *Channels) StopSigningKeyRotation() {
+	if stop := ch.signingKeyRotationStop; stop != nil {
+		close(stop)
+	}
+}
+
+func (ch *Channels) storeSigningKeyRing(ring *model.SigningKeyRing) {
+	ch.signingKeyRing.Store(ring)
+}
+
+func (ch *Channels) loadSigningKeyRing() *model.SigningKeyRing {
+	if v := ch.signingKeyRing.Load(); v != nil {
+		return v.(*model.SigningKeyRing)
+	}
+	return nil
+}
+
+// SigningKeyByKid returns the active or not-yet-retired private key whose
+// kid matches, for verifiers that know which key signed a given token.
+func (ch *Channels) SigningKeyByKid(kid string) (*ecdsa.PrivateKey, bool) {
+	ring := ch.loadSigningKeyRing()
+	if ring == nil {
+		return nil, false
+	}
+	for _, entry := range ring.Keys {
+		if entry.Kid == kid && entry.RetiredAt == 0 {
+			return signingKeyRingEntryToPrivateKey(entry), true
+		}
+	}
+	return nil, false
+}
+
+// ActiveSigningKeys returns every key in the ring that has not yet been
+// retired, newest first. Verifiers that don't have a kid to go on (legacy
+// tokens) fall back to trying each of these in turn.
+func (ch *Channels) ActiveSigningKeys() []*ecdsa.PrivateKey {
+	ring := ch.loadSigningKeyRing()
+	if ring == nil {
+		return nil
+	}
+
+	keys := make([]*ecdsa.PrivateKey, 0, len(ring.Keys))
+	for _, entry := range ring.Keys {
+		if entry.RetiredAt != 0 {
+			continue
+		}
+		keys = append(keys, signingKeyRingEntryToPrivateKey(entry))
+	}
+	return keys
+}
+
+// signingKeyRingEntryToPrivateKey rebuilds the *ecdsa.PrivateKey encoded by
+// a ring entry's raw curve point, mirroring how ensureAsymmetricSigningKey
+// reconstructs the single legacy key.
+func signingKeyRingEntryToPrivateKey(entry *model.SigningKeyRingEntry) *ecdsa.PrivateKey {
+	var curve elliptic.Curve
+	switch entry.ECDSAKey.Curve {
+	case "P-256":
+		curve = elliptic.P256()
+	default:
+		return nil
+	}
+	return &ecdsa.PrivateKey{
+		PublicKey: ecdsa.PublicKey{
+			Curve: curve,
+			X:     entry.ECDSAKey.X,
+			Y:     entry.ECDSAKey.Y,
+		},
+		D: entry.ECDSAKey.D,
+	}
+}
+
+// base64URLEncodeBigInt encodes a big.Int as unpadded base64url, the
+// encoding JWK (RFC 7518) requires for EC coordinates.
+func base64URLEncodeBigInt(n *big.Int) string {
+	return base64.RawURLEncoding.EncodeToString(n.Bytes())
+}
+
+// currentSigningKid returns the kid of the newest, non-retired key in the
+// ring, i.e. the one new tokens should be signed with.
+func (ch *Channels) currentSigningKid() string {
+	ring := ch.loadSigningKeyRing()
+	if ring == nil {
+		return ""
+	}
+	for _, entry := range ring.Keys {
+		if entry.RetiredAt == 0 {
+			return entry.Kid
+		}
+	}
+	return ""
+}
+
+// JWKS builds a JWKS-formatted document (RFC 7517) from every
+// not-yet-retired key in the ring, for publishing at
+// GET /api/v4/system/jwks.
+func (ch *Channels) JWKS() (*model.Jwks, *model.AppError) {
+	ring := ch.loadSigningKeyRing()
+	if ring == nil {
+		return nil, model.NewAppError("JWKS", "app.signing_key.no_ring.app_error", nil, "", 500)
+	}
+
+	jwks := &model.Jwks{Keys: make([]model.JwksKey, 0, len(ring.Keys))}
+	for _, entry := range ring.Keys {
+		if entry.RetiredAt != 0 {
+			continue
+		}
+		key := signingKeyRingEntryToPrivateKey(entry)
+		jwks.Keys = append(jwks.Keys, model.JwksKey{
+			Kid: entry.Kid,
+			Kty: "EC",
+			Crv: "P-256",
+			X:   base64URLEncodeBigInt(key.X),
+			Y:   base64URLEncodeBigInt(key.Y),
+			Use: "sig",
+		})
+	}
+	return jwks, nil
+}
+
+// ServeJWKS writes the JWKS document for GET /api/v4/system/jwks. It takes
+// no session/permission arguments because the JWKS is, by definition,
+// public: it only ever contains public key material, the same trust model
+// as AsymmetricSigningPublicKey already had on the unauthenticated client
+// config endpoint.
+func (a *App) ServeJWKS(w http.ResponseWriter, r *http.Request) {
+	jwks, appErr := a.ch.JWKS()
+	if appErr != nil {
+		w.WriteHeader(appErr.StatusCode)
+		_ = json.NewEncoder(w).Encode(appErr)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	if err := json.NewEncoder(w).Encode(jwks); err != nil {
+		mlog.Error("Failed to write JWKS response", mlog.Err(err))
+	}
+}
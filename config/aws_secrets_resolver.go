@@ -0,0 +1,50 @@
+// Copyright (c) 2015-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package config
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"github.com/pkg/errors"
+)
+
+// AWSSecretsManagerResolver resolves
+// ${secret:awssm://<secret-id>#<field>} references against AWS Secrets
+// Manager. Each secret is stored as a JSON object so that one secret-id
+// can back several config fields.
+type AWSSecretsManagerResolver struct {
+	client *secretsmanager.Client
+}
+
+// NewAWSSecretsManagerResolver returns a SecretResolver backed by client.
+func NewAWSSecretsManagerResolver(client *secretsmanager.Client) *AWSSecretsManagerResolver {
+	return &AWSSecretsManagerResolver{client: client}
+}
+
+func (r *AWSSecretsManagerResolver) Provider() string {
+	return "awssm"
+}
+
+func (r *AWSSecretsManagerResolver) Resolve(path, field string) (string, time.Duration, error) {
+	out, err := r.client.GetSecretValue(context.Background(), &secretsmanager.GetSecretValueInput{
+		SecretId: &path,
+	})
+	if err != nil {
+		return "", 0, errors.Wrapf(err, "failed to read AWS secret %s", path)
+	}
+
+	var values map[string]string
+	if err := json.Unmarshal([]byte(*out.SecretString), &values); err != nil {
+		return "", 0, errors.Wrapf(err, "AWS secret %s is not a flat JSON object", path)
+	}
+
+	value, ok := values[field]
+	if !ok {
+		return "", 0, errors.Errorf("AWS secret %s has no field %q", path, field)
+	}
+	return value, defaultSecretTTL, nil
+}
@@ -0,0 +1,53 @@
+// Copyright (c) 2015-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package config
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+
+	"github.com/pkg/errors"
+
+	"github.com/mattermost/mattermost-server/v6/model"
+)
+
+// ErrPreconditionFailed is the error reported by a compare-and-swap save
+// when the caller's ifMatch ETag no longer matches the stored
+// configuration, i.e. someone else wrote a newer version first. Store.Set
+// itself takes no ifMatch and never returns it.
+var ErrPreconditionFailed = errors.New("config: stored configuration has changed since ifMatch was read")
+
+// ComputeETag hashes the canonical (key-sorted) JSON encoding of cfg so
+// two semantically identical configs always produce the same ETag
+// regardless of in-memory field ordering.
+func ComputeETag(cfg *model.Config) (string, error) {
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to marshal config for ETag")
+	}
+
+	// Round-trip through a map so struct field order never affects the
+	// hash, only the sorted key order json.Marshal already guarantees for
+	// maps.
+	var asMap map[string]interface{}
+	if err := json.Unmarshal(data, &asMap); err != nil {
+		return "", errors.Wrap(err, "failed to canonicalize config for ETag")
+	}
+	canonical, err := json.Marshal(asMap)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to marshal canonical config")
+	}
+
+	sum := sha256.Sum256(canonical)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// VersionedConfig pairs a persisted config with the bookkeeping needed for
+// compare-and-swap writes and cluster version-vector resolution.
+type VersionedConfig struct {
+	Config  *model.Config `json:"config"`
+	Version int64         `json:"version"`
+	ETag    string        `json:"etag"`
+}
@@ -0,0 +1,95 @@
+// Copyright (c) 2015-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package config
+
+import (
+	"context"
+	"encoding/json"
+
+	consulapi "github.com/hashicorp/consul/api"
+	"github.com/pkg/errors"
+
+	"github.com/mattermost/mattermost-server/v6/model"
+)
+
+// ConsulSource reads the config from a single key in Consul's KV store and
+// uses a blocking query to watch for changes.
+type ConsulSource struct {
+	client   *consulapi.Client
+	key      string
+	writable bool
+}
+
+// NewConsulSource connects to addr and watches key for config updates.
+func NewConsulSource(addr, key string, writable bool) (*ConsulSource, error) {
+	client, err := consulapi.NewClient(&consulapi.Config{Address: addr})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create consul client")
+	}
+	return &ConsulSource{client: client, key: key, writable: writable}, nil
+}
+
+func (s *ConsulSource) Name() string {
+	return "consul:" + s.key
+}
+
+func (s *ConsulSource) Writable() bool {
+	return s.writable
+}
+
+func (s *ConsulSource) Load() (*model.Config, error) {
+	pair, _, err := s.client.KV().Get(s.key, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read consul key")
+	}
+	if pair == nil {
+		return &model.Config{}, nil
+	}
+
+	cfg := &model.Config{}
+	if err := json.Unmarshal(pair.Value, cfg); err != nil {
+		return nil, errors.Wrap(err, "failed to parse consul config")
+	}
+	return cfg, nil
+}
+
+func (s *ConsulSource) Save(cfg *model.Config) error {
+	if !s.writable {
+		return errors.New("config: consul source is read-only")
+	}
+
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal config")
+	}
+	_, err = s.client.KV().Put(&consulapi.KVPair{Key: s.key, Value: data}, nil)
+	return err
+}
+
+// Watch long-polls the Consul KV endpoint for changes to key, using the
+// returned index to block until the value is modified.
+func (s *ConsulSource) Watch(ctx context.Context, onChange func()) error {
+	var waitIndex uint64
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		default:
+		}
+
+		pair, meta, err := s.client.KV().Get(s.key, &consulapi.QueryOptions{
+			WaitIndex: waitIndex,
+			Context:   ctx,
+		})
+		if err != nil {
+			continue
+		}
+		if meta != nil && meta.LastIndex != waitIndex {
+			waitIndex = meta.LastIndex
+			if pair != nil {
+				onChange()
+			}
+		}
+	}
+}
@@ -0,0 +1,73 @@
+// Copyright (c) 2015-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package config
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/pkg/errors"
+
+	"github.com/mattermost/mattermost-server/v6/model"
+	"github.com/mattermost/mattermost-server/v6/store"
+)
+
+// systemConfigKey is the System table row the database source reads and
+// writes the full serialized config under.
+const systemConfigKey = "Configuration"
+
+// DatabaseSource stores the config as a single JSON blob in the System
+// table, matching the legacy (pre-layered) database config store.
+type DatabaseSource struct {
+	systemStore store.SystemStore
+	writable    bool
+}
+
+// NewDatabaseSource returns a ConfigSource backed by systemStore.
+func NewDatabaseSource(systemStore store.SystemStore, writable bool) *DatabaseSource {
+	return &DatabaseSource{systemStore: systemStore, writable: writable}
+}
+
+func (s *DatabaseSource) Name() string {
+	return "database"
+}
+
+func (s *DatabaseSource) Writable() bool {
+	return s.writable
+}
+
+func (s *DatabaseSource) Load() (*model.Config, error) {
+	system, err := s.systemStore.GetByName(systemConfigKey)
+	if err != nil {
+		// No row yet means no overrides from this layer.
+		return &model.Config{}, nil
+	}
+
+	cfg := &model.Config{}
+	if err := json.Unmarshal([]byte(system.Value), cfg); err != nil {
+		return nil, errors.Wrap(err, "failed to parse database config")
+	}
+	return cfg, nil
+}
+
+func (s *DatabaseSource) Save(cfg *model.Config) error {
+	if !s.writable {
+		return errors.New("config: database source is read-only")
+	}
+
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal config")
+	}
+	return s.systemStore.SaveOrUpdate(&model.System{
+		Name:  systemConfigKey,
+		Value: string(data),
+	})
+}
+
+// Watch is unsupported: database changes are picked up via the existing
+// cluster ConfigChanged broadcast rather than polling the table.
+func (s *DatabaseSource) Watch(ctx context.Context, onChange func()) error {
+	return ErrWatchUnsupported
+}
@@ -0,0 +1,128 @@
+// Copyright (c) 2015-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package config
+
+import (
+	"context"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/mattermost/mattermost-server/v6/model"
+)
+
+// EnvPrefix is the prefix environment overrides are read from, e.g.
+// MM_SERVICESETTINGS_SITEURL.
+const EnvPrefix = "MM_"
+
+// EnvSource derives a config from MM_-prefixed environment variables. It
+// always wins over any lower-precedence layer it's placed after, is never
+// writable, and never changes without a process restart, so it does not
+// support Watch.
+type EnvSource struct{}
+
+// NewEnvSource returns a ConfigSource backed by the process environment.
+func NewEnvSource() *EnvSource {
+	return &EnvSource{}
+}
+
+func (s *EnvSource) Name() string {
+	return "env"
+}
+
+func (s *EnvSource) Writable() bool {
+	return false
+}
+
+func (s *EnvSource) Watch(ctx context.Context, onChange func()) error {
+	return ErrWatchUnsupported
+}
+
+// Load returns a sparse *model.Config containing only the fields that have
+// a matching MM_-prefixed environment variable set.
+func (s *EnvSource) Load() (*model.Config, error) {
+	cfg := &model.Config{}
+	setEnvOverrides(reflect.ValueOf(cfg).Elem(), []string{EnvPrefix[:len(EnvPrefix)-1]})
+	return cfg, nil
+}
+
+// setEnvOverrides walks a settings struct by reflection, assigning any
+// field whose underlying environment variable (path joined with
+// underscores, e.g. MM_SERVICESETTINGS_SITEURL) is set. It never allocates
+// a nil pointer unless something under it actually has a matching env var
+// set, so a field nobody overrode comes back out of Load as nil rather
+// than a zero-valued pointer that would outrank a real value from a
+// lower-precedence source during the merge.
+func setEnvOverrides(v reflect.Value, path []string) {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if !v.CanSet() {
+			return
+		}
+		if v.IsNil() {
+			if !hasEnvOverrideUnder(v.Type().Elem(), path) {
+				return
+			}
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		setEnvOverrides(v.Elem(), path)
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if field.PkgPath != "" {
+				continue
+			}
+			setEnvOverrides(v.Field(i), append(path, strings.ToUpper(field.Name)))
+		}
+	default:
+		envName := strings.Join(path, "_")
+		raw, ok := os.LookupEnv(envName)
+		if !ok || !v.CanSet() {
+			return
+		}
+		assignScalar(v, raw)
+	}
+}
+
+// hasEnvOverrideUnder reports whether any env var matching path or one of
+// its descendants is set, without allocating anything. It lets
+// setEnvOverrides decide whether a nil pointer is worth allocating before
+// it does so.
+func hasEnvOverrideUnder(t reflect.Type, path []string) bool {
+	switch t.Kind() {
+	case reflect.Ptr:
+		return hasEnvOverrideUnder(t.Elem(), path)
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if field.PkgPath != "" {
+				continue
+			}
+			if hasEnvOverrideUnder(field.Type, append(append([]string{}, path...), strings.ToUpper(field.Name))) {
+				return true
+			}
+		}
+		return false
+	default:
+		_, ok := os.LookupEnv(strings.Join(path, "_"))
+		return ok
+	}
+}
+
+func assignScalar(v reflect.Value, raw string) {
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(raw)
+	case reflect.Bool:
+		if b, err := strconv.ParseBool(raw); err == nil {
+			v.SetBool(b)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if n, err := strconv.ParseInt(raw, 10, 64); err == nil {
+			v.SetInt(n)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+// Copyright (c) 2015-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package config
+
+import (
+	"reflect"
+	"testing"
+)
+
+type envTestNested struct {
+	SiteURL *string
+	Port    *int
+}
+
+type envTestRoot struct {
+	ServiceSettings envTestNested
+	SqlSettings     *envTestNested
+}
+
+// TestSetEnvOverridesLeavesUnsetFieldsNil guards against regressing to
+// unconditionally allocating every pointer field: a field with no matching
+// MM_-prefixed env var set must come back nil so a lower-precedence source
+// can still supply it.
+func TestSetEnvOverridesLeavesUnsetFieldsNil(t *testing.T) {
+	t.Setenv("MM_SERVICESETTINGS_SITEURL", "https://example.com")
+
+	root := &envTestRoot{}
+	setEnvOverrides(reflect.ValueOf(root).Elem(), []string{"MM"})
+
+	if root.ServiceSettings.SiteURL == nil || *root.ServiceSettings.SiteURL != "https://example.com" {
+		t.Fatalf("expected SiteURL to be set from env, got %v", root.ServiceSettings.SiteURL)
+	}
+	if root.ServiceSettings.Port != nil {
+		t.Fatalf("expected Port to stay nil, got %v", *root.ServiceSettings.Port)
+	}
+	if root.SqlSettings != nil {
+		t.Fatalf("expected SqlSettings to stay nil since nothing under it was overridden, got %+v", root.SqlSettings)
+	}
+}
+
+func TestSetEnvOverridesAllocatesOnlyWhenNeeded(t *testing.T) {
+	t.Setenv("MM_SQLSETTINGS_PORT", "5432")
+
+	root := &envTestRoot{}
+	setEnvOverrides(reflect.ValueOf(root).Elem(), []string{"MM"})
+
+	if root.SqlSettings == nil {
+		t.Fatal("expected SqlSettings to be allocated since Port was overridden")
+	}
+	if root.SqlSettings.Port == nil || *root.SqlSettings.Port != 5432 {
+		t.Fatalf("expected Port to be set from env, got %v", root.SqlSettings.Port)
+	}
+	if root.SqlSettings.SiteURL != nil {
+		t.Fatalf("expected SiteURL to stay nil, got %v", *root.SqlSettings.SiteURL)
+	}
+}
@@ -0,0 +1,89 @@
+// Copyright (c) 2015-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package config
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/pkg/errors"
+	clientv3 "go.etcd.io/etcd/client/v3"
+
+	"github.com/mattermost/mattermost-server/v6/model"
+)
+
+// EtcdSource reads the config from a single key in etcd and watches that
+// key for changes via etcd's native watch API.
+type EtcdSource struct {
+	client   *clientv3.Client
+	key      string
+	writable bool
+}
+
+// NewEtcdSource connects to the given etcd endpoints and watches key for
+// config updates.
+func NewEtcdSource(endpoints []string, key string, writable bool) (*EtcdSource, error) {
+	client, err := clientv3.New(clientv3.Config{Endpoints: endpoints})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create etcd client")
+	}
+	return &EtcdSource{client: client, key: key, writable: writable}, nil
+}
+
+func (s *EtcdSource) Name() string {
+	return "etcd:" + s.key
+}
+
+func (s *EtcdSource) Writable() bool {
+	return s.writable
+}
+
+func (s *EtcdSource) Load() (*model.Config, error) {
+	resp, err := s.client.Get(context.Background(), s.key)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read etcd key")
+	}
+	if len(resp.Kvs) == 0 {
+		return &model.Config{}, nil
+	}
+
+	cfg := &model.Config{}
+	if err := json.Unmarshal(resp.Kvs[0].Value, cfg); err != nil {
+		return nil, errors.Wrap(err, "failed to parse etcd config")
+	}
+	return cfg, nil
+}
+
+func (s *EtcdSource) Save(cfg *model.Config) error {
+	if !s.writable {
+		return errors.New("config: etcd source is read-only")
+	}
+
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal config")
+	}
+	_, err = s.client.Put(context.Background(), s.key, string(data))
+	return err
+}
+
+func (s *EtcdSource) Watch(ctx context.Context, onChange func()) error {
+	watchChan := s.client.Watch(ctx, s.key)
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case resp, ok := <-watchChan:
+			if !ok {
+				return nil
+			}
+			if resp.Err() != nil {
+				continue
+			}
+			if len(resp.Events) > 0 {
+				onChange()
+			}
+		}
+	}
+}
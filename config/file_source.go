@@ -0,0 +1,90 @@
+// Copyright (c) 2015-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package config
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/mattermost/mattermost-server/v6/model"
+)
+
+// FileSource reads configuration from a JSON file on disk and polls it for
+// changes, matching the on-disk config.json format used outside of the
+// layered store.
+type FileSource struct {
+	path     string
+	writable bool
+}
+
+// NewFileSource returns a ConfigSource backed by the JSON file at path.
+func NewFileSource(path string, writable bool) *FileSource {
+	return &FileSource{path: path, writable: writable}
+}
+
+func (s *FileSource) Name() string {
+	return "file:" + s.path
+}
+
+func (s *FileSource) Load() (*model.Config, error) {
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read config file %s", s.path)
+	}
+
+	cfg := &model.Config{}
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return nil, errors.Wrapf(err, "failed to parse config file %s", s.path)
+	}
+	return cfg, nil
+}
+
+func (s *FileSource) Save(cfg *model.Config) error {
+	if !s.writable {
+		return errors.Errorf("config: file source %s is read-only", s.path)
+	}
+
+	data, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal config")
+	}
+	return os.WriteFile(s.path, data, 0644)
+}
+
+func (s *FileSource) Writable() bool {
+	return s.writable
+}
+
+// Watch polls the file's modification time, since config.json lives
+// outside of any backend with native change notifications.
+func (s *FileSource) Watch(ctx context.Context, onChange func()) error {
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return errors.Wrapf(err, "failed to stat config file %s", s.path)
+	}
+	lastMod := info.ModTime()
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			info, err := os.Stat(s.path)
+			if err != nil {
+				continue
+			}
+			if info.ModTime().After(lastMod) {
+				lastMod = info.ModTime()
+				onChange()
+			}
+		}
+	}
+}
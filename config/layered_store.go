@@ -0,0 +1,226 @@
+// Copyright (c) 2015-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package config
+
+import (
+	"context"
+	"sync"
+
+	"github.com/pkg/errors"
+
+	"github.com/mattermost/mattermost-server/v6/model"
+)
+
+// ErrUnownedKey is returned when SaveConfig would need to write a field
+// that no writable source claims.
+var ErrUnownedKey = errors.New("config: no writable source owns this configuration")
+
+// LayeredStore merges configuration from one or more ConfigSource in
+// declared precedence order: sources later in the slice override fields
+// set by earlier ones. Alongside the merged *model.Config it keeps a
+// parallel fieldPath -> source name provenance map so operators can see
+// where any given value came from.
+type LayeredStore struct {
+	mu         sync.RWMutex
+	sources    []ConfigSource
+	writable   ConfigSource
+	merged     *model.Config
+	provenance map[string]string
+
+	listenerMu sync.Mutex
+	listeners  map[string]func(*model.Config, *model.Config)
+}
+
+// NewLayeredStore builds a LayeredStore from sources in precedence order
+// and performs an initial load. writable names the source SaveConfig
+// persists to; it must also appear in sources. A nil writable makes the
+// store read-only.
+func NewLayeredStore(sources []ConfigSource, writable ConfigSource) (*LayeredStore, error) {
+	ls := &LayeredStore{
+		sources:   sources,
+		writable:  writable,
+		listeners: make(map[string]func(*model.Config, *model.Config)),
+	}
+	if err := ls.reload(); err != nil {
+		return nil, err
+	}
+	return ls, nil
+}
+
+// Watch starts watching every source that supports it, re-merging and
+// firing listeners whenever any of them report a change. It returns once
+// all watches have been started; the watches themselves run until ctx is
+// cancelled.
+func (ls *LayeredStore) Watch(ctx context.Context) error {
+	for _, src := range ls.sources {
+		src := src
+		go func() {
+			if err := src.Watch(ctx, func() { ls.reload() }); err != nil && errors.Cause(err) != ErrWatchUnsupported {
+				// Best effort: a misbehaving backend shouldn't take the
+				// rest of the layers down with it.
+			}
+		}()
+	}
+	return nil
+}
+
+func (ls *LayeredStore) reload() error {
+	merged := map[string]interface{}{}
+	provenance := make(map[string]string)
+
+	for _, src := range ls.sources {
+		cfg, err := src.Load()
+		if err != nil {
+			return errors.Wrapf(err, "failed to load config from source %q", src.Name())
+		}
+		if err := mergeConfigInto(merged, cfg, src.Name(), provenance); err != nil {
+			return err
+		}
+	}
+
+	newCfg, err := decodeMergedConfig(merged)
+	if err != nil {
+		return errors.Wrap(err, "failed to decode merged configuration")
+	}
+	newCfg.SetDefaults()
+	if err := newCfg.IsValid(); err != nil {
+		return errors.Wrap(err, "merged configuration is invalid")
+	}
+
+	ls.mu.Lock()
+	old := ls.merged
+	ls.merged = newCfg
+	ls.provenance = provenance
+	ls.mu.Unlock()
+
+	for _, listener := range ls.listenersSnapshot() {
+		listener(old, newCfg)
+	}
+	return nil
+}
+
+// Get returns the current merged configuration.
+func (ls *LayeredStore) Get() *model.Config {
+	ls.mu.RLock()
+	defer ls.mu.RUnlock()
+	return ls.merged
+}
+
+// Provenance returns a copy of the fieldPath -> source name map for the
+// current merged configuration.
+func (ls *LayeredStore) Provenance() map[string]string {
+	ls.mu.RLock()
+	defer ls.mu.RUnlock()
+
+	out := make(map[string]string, len(ls.provenance))
+	for k, v := range ls.provenance {
+		out[k] = v
+	}
+	return out
+}
+
+// Set writes newCfg to the configured writable source and re-merges. It
+// returns ErrUnownedKey when no writable source is configured, and
+// ErrUnownedKey wrapped with the offending field paths when newCfg
+// changes a field whose current value is owned
+// by a later (and therefore higher-precedence) source: writing it to
+// ls.writable would be silently discarded on the very next reload once
+// that other source's value wins the merge again, so Set refuses rather
+// than accept a change that can never stick.
+func (ls *LayeredStore) Set(newCfg *model.Config) (*model.Config, *model.Config, error) {
+	if ls.writable == nil {
+		return nil, nil, ErrUnownedKey
+	}
+
+	writer, ok := ls.writable.(interface {
+		Save(*model.Config) error
+	})
+	if !ok {
+		return nil, nil, errors.Errorf("config: source %q does not support writes", ls.writable.Name())
+	}
+
+	old := ls.Get()
+	if unowned, err := ls.unownedChanges(old, newCfg); err != nil {
+		return nil, nil, err
+	} else if len(unowned) > 0 {
+		return nil, nil, errors.Wrapf(ErrUnownedKey, "fields %v are owned by a higher-precedence source than %q", unowned, ls.writable.Name())
+	}
+
+	if err := writer.Save(newCfg); err != nil {
+		return nil, nil, errors.Wrapf(err, "failed to save config to source %q", ls.writable.Name())
+	}
+	if err := ls.reload(); err != nil {
+		return nil, nil, err
+	}
+	return old, ls.Get(), nil
+}
+
+// unownedChanges returns the changed leaf paths (old vs newCfg) that a
+// higher-precedence source than ls.writable currently owns, i.e. paths
+// whose new value ls.writable cannot actually make stick.
+func (ls *LayeredStore) unownedChanges(old, newCfg *model.Config) ([]string, error) {
+	changed, err := changedLeafPaths(old, newCfg)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to diff config for ownership check")
+	}
+	if len(changed) == 0 {
+		return nil, nil
+	}
+
+	writableIdx := -1
+	for i, src := range ls.sources {
+		if src.Name() == ls.writable.Name() {
+			writableIdx = i
+			break
+		}
+	}
+
+	precedence := make(map[string]int, len(ls.sources))
+	for i, src := range ls.sources {
+		precedence[src.Name()] = i
+	}
+
+	provenance := ls.Provenance()
+	var unowned []string
+	for _, path := range changed {
+		owner, ok := provenance[path]
+		if !ok {
+			continue
+		}
+		if ownerIdx, ok := precedence[owner]; ok && ownerIdx > writableIdx {
+			unowned = append(unowned, path)
+		}
+	}
+	return unowned, nil
+}
+
+// AddListener registers a function to be called with the old and new
+// merged config whenever any layer changes. It returns an ID that can
+// later be passed to RemoveListener.
+func (ls *LayeredStore) AddListener(listener func(*model.Config, *model.Config)) string {
+	ls.listenerMu.Lock()
+	defer ls.listenerMu.Unlock()
+
+	id := model.NewId()
+	ls.listeners[id] = listener
+	return id
+}
+
+// RemoveListener removes a listener previously registered with AddListener.
+func (ls *LayeredStore) RemoveListener(id string) {
+	ls.listenerMu.Lock()
+	defer ls.listenerMu.Unlock()
+	delete(ls.listeners, id)
+}
+
+func (ls *LayeredStore) listenersSnapshot() []func(*model.Config, *model.Config) {
+	ls.listenerMu.Lock()
+	defer ls.listenerMu.Unlock()
+
+	out := make([]func(*model.Config, *model.Config), 0, len(ls.listeners))
+	for _, l := range ls.listeners {
+		out = append(out, l)
+	}
+	return out
+}
@@ -0,0 +1,146 @@
+// Copyright (c) 2015-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/pkg/errors"
+
+	"github.com/mattermost/mattermost-server/v6/model"
+)
+
+// mergeConfigInto round-trips cfg through JSON into dst, recording the
+// source name against every non-nil leaf field path it touches.
+// Later callers win: a field written by one source and overwritten by a
+// later one ends up attributed to the later source, matching the declared
+// precedence order of the layers in LayeredStore.
+func mergeConfigInto(dst map[string]interface{}, cfg *model.Config, sourceName string, provenance map[string]string) error {
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		return errors.Wrapf(err, "failed to marshal config from source %q", sourceName)
+	}
+
+	var layer map[string]interface{}
+	if err := json.Unmarshal(data, &layer); err != nil {
+		return errors.Wrapf(err, "failed to decode config from source %q", sourceName)
+	}
+
+	mergeMap(dst, layer, "", sourceName, provenance)
+	return nil
+}
+
+func mergeMap(dst, src map[string]interface{}, prefix, sourceName string, provenance map[string]string) {
+	for k, v := range src {
+		path := k
+		if prefix != "" {
+			path = prefix + "." + k
+		}
+
+		if v == nil {
+			// A nil leaf means this source's model.Config left the field
+			// unset (e.g. EnvSource's sparse overlay), not that it wants to
+			// clobber a lower-precedence value with null. Treat it as
+			// absent so precedence still falls through to whichever source
+			// actually set it.
+			continue
+		}
+
+		if srcChild, ok := v.(map[string]interface{}); ok {
+			dstChild, ok := dst[k].(map[string]interface{})
+			if !ok {
+				dstChild = map[string]interface{}{}
+				dst[k] = dstChild
+			}
+			mergeMap(dstChild, srcChild, path, sourceName, provenance)
+			continue
+		}
+
+		dst[k] = v
+		provenance[path] = sourceName
+	}
+}
+
+// changedLeafPaths returns every dotted leaf field path whose value differs
+// between old and newCfg, for callers that need to know which fields a
+// write would actually touch (e.g. LayeredStore.Set checking ownership).
+func changedLeafPaths(old, newCfg *model.Config) ([]string, error) {
+	oldMap, err := toJSONMap(old)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to marshal old config")
+	}
+	newMap, err := toJSONMap(newCfg)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to marshal new config")
+	}
+
+	var paths []string
+	collectChangedLeafPaths(oldMap, newMap, "", &paths)
+	return paths, nil
+}
+
+func toJSONMap(cfg *model.Config) (map[string]interface{}, error) {
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		return nil, err
+	}
+	m := map[string]interface{}{}
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func collectChangedLeafPaths(oldMap, newMap map[string]interface{}, prefix string, paths *[]string) {
+	seen := make(map[string]bool, len(oldMap)+len(newMap))
+	for k := range oldMap {
+		seen[k] = true
+	}
+	for k := range newMap {
+		seen[k] = true
+	}
+
+	for k := range seen {
+		path := k
+		if prefix != "" {
+			path = prefix + "." + k
+		}
+
+		oldVal, newVal := oldMap[k], newMap[k]
+		oldChild, oldIsMap := oldVal.(map[string]interface{})
+		newChild, newIsMap := newVal.(map[string]interface{})
+		if oldIsMap || newIsMap {
+			if !oldIsMap {
+				oldChild = map[string]interface{}{}
+			}
+			if !newIsMap {
+				newChild = map[string]interface{}{}
+			}
+			collectChangedLeafPaths(oldChild, newChild, path, paths)
+			continue
+		}
+
+		oldJSON, _ := json.Marshal(oldVal)
+		newJSON, _ := json.Marshal(newVal)
+		if string(oldJSON) != string(newJSON) {
+			*paths = append(*paths, path)
+		}
+	}
+}
+
+// decodeMergedConfig converts the merged JSON-shaped map back into a
+// *model.Config.
+func decodeMergedConfig(merged map[string]interface{}) (*model.Config, error) {
+	data, err := json.Marshal(merged)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal merged config: %w", err)
+	}
+
+	cfg := &model.Config{}
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("failed to decode merged config: %w", err)
+	}
+	return cfg, nil
+}
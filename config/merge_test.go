@@ -0,0 +1,80 @@
+// Copyright (c) 2015-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package config
+
+import "testing"
+
+// TestMergeMapSkipsNilLeaves guards against a sparse layer (e.g. EnvSource)
+// clobbering a lower-precedence value with an explicit JSON null for every
+// field it left unset.
+func TestMergeMapSkipsNilLeaves(t *testing.T) {
+	dst := map[string]interface{}{
+		"ServiceSettings": map[string]interface{}{
+			"SiteURL": "https://from-file.example.com",
+			"Port":    float64(8065),
+		},
+	}
+	provenance := map[string]string{}
+
+	src := map[string]interface{}{
+		"ServiceSettings": map[string]interface{}{
+			"SiteURL": nil,
+			"Port":    float64(9000),
+		},
+	}
+	mergeMap(dst, src, "", "env", provenance)
+
+	svc := dst["ServiceSettings"].(map[string]interface{})
+	if svc["SiteURL"] != "https://from-file.example.com" {
+		t.Fatalf("expected SiteURL to survive the nil overlay, got %v", svc["SiteURL"])
+	}
+	if svc["Port"] != float64(9000) {
+		t.Fatalf("expected Port to be overridden, got %v", svc["Port"])
+	}
+	if provenance["ServiceSettings.SiteURL"] == "env" {
+		t.Fatal("expected provenance for SiteURL to remain attributed to the earlier source")
+	}
+	if provenance["ServiceSettings.Port"] != "env" {
+		t.Fatalf("expected provenance for Port to be attributed to env, got %v", provenance["ServiceSettings.Port"])
+	}
+}
+
+// TestCollectChangedLeafPaths guards LayeredStore.Set's ownership check:
+// it must report exactly the leaf paths that differ, including ones
+// nested under a key added or removed wholesale, and nothing for a field
+// left equal on both sides.
+func TestCollectChangedLeafPaths(t *testing.T) {
+	old := map[string]interface{}{
+		"ServiceSettings": map[string]interface{}{
+			"SiteURL": "https://old.example.com",
+			"Port":    float64(8065),
+		},
+	}
+	newMap := map[string]interface{}{
+		"ServiceSettings": map[string]interface{}{
+			"SiteURL": "https://new.example.com",
+			"Port":    float64(8065),
+		},
+		"PluginSettings": map[string]interface{}{
+			"Enable": true,
+		},
+	}
+
+	var paths []string
+	collectChangedLeafPaths(old, newMap, "", &paths)
+
+	changed := map[string]bool{}
+	for _, p := range paths {
+		changed[p] = true
+	}
+	if !changed["ServiceSettings.SiteURL"] {
+		t.Fatal("expected ServiceSettings.SiteURL to be reported as changed")
+	}
+	if changed["ServiceSettings.Port"] {
+		t.Fatal("expected ServiceSettings.Port not to be reported, it's unchanged")
+	}
+	if !changed["PluginSettings.Enable"] {
+		t.Fatal("expected PluginSettings.Enable to be reported as changed since it's new")
+	}
+}
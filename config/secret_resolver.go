@@ -0,0 +1,273 @@
+// Copyright (c) 2015-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package config
+
+import (
+	"reflect"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/mattermost/mattermost-server/v6/model"
+)
+
+// secretRefPattern matches a config string field whose whole value is a
+// ${secret:<provider>://<path>#<field>} reference, e.g.
+// ${secret:vault://secret/data/smtp#password}.
+var secretRefPattern = regexp.MustCompile(`^\$\{secret:([a-z0-9_-]+)://([^#]+)#([^}]+)\}$`)
+
+// SecretResolver fetches plaintext secret material from an external store
+// (Vault, AWS Secrets Manager, GCP Secret Manager, ...) on behalf of
+// config fields written as ${secret:<provider>://<path>#<field>}.
+type SecretResolver interface {
+	// Provider is the scheme this resolver answers for, e.g. "vault".
+	Provider() string
+
+	// Resolve fetches the plaintext value of field at path, along with
+	// how long it may be cached before Resolve should be called again.
+	Resolve(path, field string) (value string, ttl time.Duration, err error)
+}
+
+// SecretWriter is implemented by resolvers whose backend can also store
+// secret material, letting operators move server-generated secrets
+// (PostActionCookieSecret, AsymmetricSigningKey) off the System table.
+type SecretWriter interface {
+	WriteField(path, field, value string) error
+}
+
+// Resolver looks up the resolver registered for provider, for callers
+// (such as ensurePostActionCookieSecret) that want to read or write a
+// specific secret directly rather than resolving a ${secret:...} string.
+func (r *SecretRegistry) Resolver(provider string) (SecretResolver, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	resolver, ok := r.resolvers[provider]
+	return resolver, ok
+}
+
+type cachedSecret struct {
+	ref       string
+	value     string
+	expiresAt time.Time
+}
+
+// SecretRegistry resolves ${secret:...} references found in a *model.Config
+// against a set of registered SecretResolver, caching plaintext in memory
+// only and re-resolving once a lease expires.
+type SecretRegistry struct {
+	mu        sync.Mutex
+	resolvers map[string]SecretResolver
+	cache     map[string]cachedSecret // ref -> cached value
+	onRotate  func(*model.Config)
+
+	// resolvedPaths records, for the most recent Resolve call, which
+	// dotted struct-field path each ${secret:...} reference was found at.
+	// Redact uses this instead of matching on plaintext value so two
+	// distinct references that happen to resolve to the same value can
+	// never be cross-wired back to the wrong one.
+	resolvedPaths map[string]string // field path -> ref
+}
+
+// NewSecretRegistry returns an empty registry. Register resolvers with
+// RegisterResolver before calling Resolve.
+func NewSecretRegistry() *SecretRegistry {
+	return &SecretRegistry{
+		resolvers:     make(map[string]SecretResolver),
+		cache:         make(map[string]cachedSecret),
+		resolvedPaths: make(map[string]string),
+	}
+}
+
+// RegisterResolver adds (or replaces) the resolver for its provider scheme.
+func (r *SecretRegistry) RegisterResolver(resolver SecretResolver) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.resolvers[resolver.Provider()] = resolver
+}
+
+// OnRotate registers a callback invoked with a freshly resolved config
+// whenever a cached secret's lease expires, so callers can feed it through
+// the existing AddConfigListener pipeline and reconnect SMTP/SQL/etc.
+func (r *SecretRegistry) OnRotate(f func(*model.Config)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.onRotate = f
+}
+
+// Resolve returns a copy of cfg with every ${secret:...} string field
+// replaced by its resolved plaintext.
+func (r *SecretRegistry) Resolve(cfg *model.Config) (*model.Config, error) {
+	resolved := cfg.Clone()
+	paths := make(map[string]string)
+	if err := r.resolveValue(reflect.ValueOf(resolved).Elem(), "", paths); err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	r.resolvedPaths = paths
+	r.mu.Unlock()
+
+	return resolved, nil
+}
+
+func (r *SecretRegistry) resolveValue(v reflect.Value, path string, paths map[string]string) error {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			return nil
+		}
+		return r.resolveValue(v.Elem(), path, paths)
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < v.NumField(); i++ {
+			if !v.Field(i).CanSet() {
+				continue
+			}
+			fieldPath := t.Field(i).Name
+			if path != "" {
+				fieldPath = path + "." + fieldPath
+			}
+			if err := r.resolveValue(v.Field(i), fieldPath, paths); err != nil {
+				return err
+			}
+		}
+	case reflect.String:
+		ref := v.String()
+		match := secretRefPattern.FindStringSubmatch(ref)
+		if match == nil {
+			return nil
+		}
+		value, err := r.resolveRef(ref, match[1], match[2], match[3])
+		if err != nil {
+			return errors.Wrapf(err, "failed to resolve secret %s", ref)
+		}
+		v.SetString(value)
+		paths[path] = ref
+	}
+	return nil
+}
+
+func (r *SecretRegistry) resolveRef(ref, provider, path, field string) (string, error) {
+	r.mu.Lock()
+	if cached, ok := r.cache[ref]; ok && time.Now().Before(cached.expiresAt) {
+		r.mu.Unlock()
+		return cached.value, nil
+	}
+	resolver, ok := r.resolvers[provider]
+	r.mu.Unlock()
+	if !ok {
+		return "", errors.Errorf("no secret resolver registered for provider %q", provider)
+	}
+
+	value, ttl, err := resolver.Resolve(path, field)
+	if err != nil {
+		return "", err
+	}
+
+	r.mu.Lock()
+	r.cache[ref] = cachedSecret{ref: ref, value: value, expiresAt: time.Now().Add(ttl)}
+	r.mu.Unlock()
+	return value, nil
+}
+
+// Redact returns a copy of cfg with every string field that still holds
+// the plaintext Resolve last put there rewritten back to its original
+// ${secret:...} reference, so SaveConfig never persists plaintext. It
+// targets fields by the struct path Resolve recorded them at, not by
+// plaintext value, so two distinct secrets that happen to share the same
+// value can never redact each other's field.
+func (r *SecretRegistry) Redact(cfg *model.Config) *model.Config {
+	redacted := cfg.Clone()
+
+	r.mu.Lock()
+	paths := make(map[string]string, len(r.resolvedPaths))
+	for path, ref := range r.resolvedPaths {
+		paths[path] = ref
+	}
+	cache := make(map[string]cachedSecret, len(r.cache))
+	for ref, cached := range r.cache {
+		cache[ref] = cached
+	}
+	r.mu.Unlock()
+
+	redactValue(reflect.ValueOf(redacted).Elem(), "", paths, cache)
+	return redacted
+}
+
+func redactValue(v reflect.Value, path string, paths map[string]string, cache map[string]cachedSecret) {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if !v.IsNil() {
+			redactValue(v.Elem(), path, paths, cache)
+		}
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < v.NumField(); i++ {
+			if !v.Field(i).CanSet() {
+				continue
+			}
+			fieldPath := t.Field(i).Name
+			if path != "" {
+				fieldPath = path + "." + fieldPath
+			}
+			redactValue(v.Field(i), fieldPath, paths, cache)
+		}
+	case reflect.String:
+		ref, ok := paths[path]
+		if !ok {
+			return
+		}
+		// Only redact if the field still holds the exact plaintext we
+		// resolved at this path; if an operator has since typed a new
+		// literal value into the same field, leave it alone rather than
+		// silently reverting it to the old reference.
+		if cached, ok := cache[ref]; ok && cached.value == v.String() {
+			v.SetString(ref)
+		}
+	}
+}
+
+// StartLeaseWatcher polls every cached secret's expiry and, once it
+// passes, re-resolves the config and invokes OnRotate's callback so
+// listeners (SMTP, SQL, ...) reconnect automatically.
+func (r *SecretRegistry) StartLeaseWatcher(stop <-chan struct{}, currentConfig func() *model.Config) {
+	ticker := time.NewTicker(30 * time.Second)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				if !r.hasExpiredLease() {
+					continue
+				}
+				resolved, err := r.Resolve(currentConfig())
+				if err != nil {
+					continue
+				}
+				r.mu.Lock()
+				onRotate := r.onRotate
+				r.mu.Unlock()
+				if onRotate != nil {
+					onRotate(resolved)
+				}
+			}
+		}
+	}()
+}
+
+func (r *SecretRegistry) hasExpiredLease() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	for _, cached := range r.cache {
+		if now.After(cached.expiresAt) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,80 @@
+// Copyright (c) 2015-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package config
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSecretRefPattern(t *testing.T) {
+	match := secretRefPattern.FindStringSubmatch("${secret:vault://secret/data/smtp#password}")
+	if match == nil {
+		t.Fatal("expected a match")
+	}
+	if match[1] != "vault" || match[2] != "secret/data/smtp" || match[3] != "password" {
+		t.Fatalf("unexpected submatches: %#v", match)
+	}
+
+	if secretRefPattern.FindStringSubmatch("not-a-secret-ref") != nil {
+		t.Fatal("expected plain strings not to match")
+	}
+}
+
+type secretTestSettings struct {
+	SMTPPassword *string
+	APIKey       *string
+}
+
+type secretTestConfig struct {
+	EmailSettings secretTestSettings
+	OtherSettings secretTestSettings
+}
+
+func strPtr(s string) *string { return &s }
+
+// TestRedactDoesNotCrossWireSharedPlaintext is a regression test for
+// Redact matching on plaintext value globally: two distinct references
+// that happen to resolve to the same secret value must each redact back
+// to their own ref, not whichever one the global map last saw.
+func TestRedactDoesNotCrossWireSharedPlaintext(t *testing.T) {
+	r := NewSecretRegistry()
+	r.cache["${secret:vault://a#f}"] = cachedSecret{ref: "${secret:vault://a#f}", value: "shared-secret", expiresAt: time.Now().Add(time.Hour)}
+	r.cache["${secret:vault://b#f}"] = cachedSecret{ref: "${secret:vault://b#f}", value: "shared-secret", expiresAt: time.Now().Add(time.Hour)}
+	r.resolvedPaths = map[string]string{
+		"EmailSettings.SMTPPassword": "${secret:vault://a#f}",
+		"OtherSettings.SMTPPassword": "${secret:vault://b#f}",
+	}
+
+	cfg := &secretTestConfig{
+		EmailSettings: secretTestSettings{SMTPPassword: strPtr("shared-secret")},
+		OtherSettings: secretTestSettings{SMTPPassword: strPtr("shared-secret")},
+	}
+
+	redactValue(reflect.ValueOf(cfg).Elem(), "", r.resolvedPaths, r.cache)
+
+	if *cfg.EmailSettings.SMTPPassword != "${secret:vault://a#f}" {
+		t.Fatalf("expected EmailSettings.SMTPPassword to redact to its own ref, got %s", *cfg.EmailSettings.SMTPPassword)
+	}
+	if *cfg.OtherSettings.SMTPPassword != "${secret:vault://b#f}" {
+		t.Fatalf("expected OtherSettings.SMTPPassword to redact to its own ref, got %s", *cfg.OtherSettings.SMTPPassword)
+	}
+}
+
+// TestRedactLeavesOperatorEditedValueAlone ensures Redact doesn't revert a
+// field an operator has since overwritten with a new literal value back to
+// the stale reference just because that path was previously resolved.
+func TestRedactLeavesOperatorEditedValueAlone(t *testing.T) {
+	r := NewSecretRegistry()
+	r.cache["${secret:vault://a#f}"] = cachedSecret{ref: "${secret:vault://a#f}", value: "old-secret", expiresAt: time.Now().Add(time.Hour)}
+	r.resolvedPaths = map[string]string{"EmailSettings.SMTPPassword": "${secret:vault://a#f}"}
+
+	cfg := &secretTestConfig{EmailSettings: secretTestSettings{SMTPPassword: strPtr("typed-by-operator")}}
+	redactValue(reflect.ValueOf(cfg).Elem(), "", r.resolvedPaths, r.cache)
+
+	if *cfg.EmailSettings.SMTPPassword != "typed-by-operator" {
+		t.Fatalf("expected operator-edited value to survive redact, got %s", *cfg.EmailSettings.SMTPPassword)
+	}
+}
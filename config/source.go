@@ -0,0 +1,38 @@
+// Copyright (c) 2015-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package config
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+
+	"github.com/mattermost/mattermost-server/v6/model"
+)
+
+// ErrWatchUnsupported is returned by ConfigSource implementations whose
+// backend has no mechanism for reporting that its data has changed.
+var ErrWatchUnsupported = errors.New("config: source does not support watching")
+
+// ConfigSource is a single origin of configuration data that LayeredStore
+// can merge with others to produce the effective server configuration.
+// Implementations exist for local files, environment variables, the
+// database, and external KV backends such as Consul and etcd.
+type ConfigSource interface {
+	// Name uniquely identifies this source for provenance reporting and
+	// error messages (e.g. "file:<path>", "database", "consul:<key>").
+	Name() string
+
+	// Load reads and returns the source's current view of the config.
+	Load() (*model.Config, error)
+
+	// Watch blocks until ctx is cancelled, invoking onChange whenever the
+	// source's underlying data changes. Implementations that cannot watch
+	// for changes must return ErrWatchUnsupported.
+	Watch(ctx context.Context, onChange func()) error
+
+	// Writable reports whether SaveConfig is allowed to persist values
+	// back to this source.
+	Writable() bool
+}
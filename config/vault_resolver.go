@@ -0,0 +1,77 @@
+// Copyright (c) 2015-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package config
+
+import (
+	"time"
+
+	vaultapi "github.com/hashicorp/vault/api"
+	"github.com/pkg/errors"
+)
+
+// defaultSecretTTL is used when a backend doesn't report a lease duration
+// for the secret it returned.
+const defaultSecretTTL = 5 * time.Minute
+
+// VaultResolver resolves ${secret:vault://<path>#<field>} references
+// against a KV v2 (or transit, for raw key material) mount in HashiCorp
+// Vault.
+type VaultResolver struct {
+	client *vaultapi.Client
+}
+
+// NewVaultResolver returns a SecretResolver backed by an already
+// authenticated Vault client.
+func NewVaultResolver(client *vaultapi.Client) *VaultResolver {
+	return &VaultResolver{client: client}
+}
+
+func (r *VaultResolver) Provider() string {
+	return "vault"
+}
+
+// WriteField writes value under field in the KV v2 secret at path,
+// satisfying SecretWriter so operators can move generated server secrets
+// (PostActionCookieSecret, AsymmetricSigningKey, ...) into Vault instead
+// of the System table.
+func (r *VaultResolver) WriteField(path, field, value string) error {
+	_, err := r.client.Logical().Write(path, map[string]interface{}{
+		"data": map[string]interface{}{field: value},
+	})
+	if err != nil {
+		return errors.Wrapf(err, "failed to write vault secret %s", path)
+	}
+	return nil
+}
+
+func (r *VaultResolver) Resolve(path, field string) (string, time.Duration, error) {
+	secret, err := r.client.Logical().Read(path)
+	if err != nil {
+		return "", 0, errors.Wrapf(err, "failed to read vault secret %s", path)
+	}
+	if secret == nil {
+		return "", 0, errors.Errorf("vault secret %s not found", path)
+	}
+
+	// KV v2 nests the actual fields under "data".
+	data, ok := secret.Data["data"].(map[string]interface{})
+	if !ok {
+		data = secret.Data
+	}
+
+	raw, ok := data[field]
+	if !ok {
+		return "", 0, errors.Errorf("vault secret %s has no field %q", path, field)
+	}
+	value, ok := raw.(string)
+	if !ok {
+		return "", 0, errors.Errorf("vault secret %s field %q is not a string", path, field)
+	}
+
+	ttl := defaultSecretTTL
+	if secret.LeaseDuration > 0 {
+		ttl = time.Duration(secret.LeaseDuration) * time.Second
+	}
+	return value, ttl, nil
+}